@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"os"
 	"strings"
 
@@ -14,26 +15,69 @@ const (
 )
 
 func AddConfigFlags(fs *flag.FlagSet, c *pkg.Config) {
+	fs.StringVar(&c.IngressesData, "ingresses-data", c.IngressesData, "Ingresses data.")
+	fs.StringVar(&c.IngressesFile, "ingresses-file", c.IngressesFile, "Ingresses data JSON or YAML file path, reloaded whenever it changes.")
+	fs.DurationVar(&c.IngressesFilePollInterval, "ingresses-file-poll-interval", c.IngressesFilePollInterval, "Interval to check the ingresses file for changes, 0 disables it.")
+	fs.StringVar(&c.IngressesConfigMap, "ingresses-configmap", c.IngressesConfigMap, "Config map holding the ingresses data, as namespace/name or name. The ingresses data flags are used while it doesn't exist.")
+	fs.StringVar(&c.IngressesConfigMapKey, "ingresses-configmap-key", c.IngressesConfigMapKey, "Config map key holding the ingresses data JSON.")
+	fs.BoolVar(&c.ValidateIngressesData, "validate-ingresses-data", c.ValidateIngressesData, "Reject ingresses data not following the layout of the default template.")
+	fs.BoolVar(&c.WatchIngresses, "watch-ingresses", c.WatchIngresses, "Watch kubernetes ingress resources.")
+	fs.BoolVar(&c.UseEndpoints, "use-endpoints", c.UseEndpoints, "Route to pod endpoints instead of the service cluster IP.")
+	fs.BoolVar(&c.SyncCerts, "sync-certs", c.SyncCerts, "Write TLS certificates referenced by hosts from kubernetes secrets.")
+	fs.StringVar(&c.CertsDir, "certs-dir", c.CertsDir, "Directory where TLS certificates are written to and read from by the default template, which also uses its dhparam.pem if present.")
+	fs.StringVar(&c.KubeMasterURL, "kube-master-url", c.KubeMasterURL, "URL to reach kubernetes master.")
+	fs.StringVar(&c.KubeConfig, "kubeconfig", c.KubeConfig, "Path to a kubeconfig file, the service account is used if not set.")
+	fs.StringVar(&c.Namespace, "namespace", c.Namespace, "If present, the namespace scope, several of them can be comma-separated.")
+	fs.BoolVar(&c.AllNamespaces, "all-namespaces", c.AllNamespaces, "Watch resources in all namespaces.")
+	fs.StringVar(&c.Selector, "selector", c.Selector, "Only export services matching this label selector.")
+	fs.StringVar(&c.FieldSelector, "field-selector", c.FieldSelector, "Only export services matching this field selector.")
+	fs.BoolVar(&c.ExposeAnnotatedOnly, "expose-annotated-only", c.ExposeAnnotatedOnly, "Only export services annotated with <annotations-prefix>/expose: \"true\".")
+	fs.StringVar(&c.ServiceHostPattern, "service-host-pattern", c.ServiceHostPattern, "If present, host given to exposed services without a <annotations-prefix>/hostname annotation, {service} and {namespace} are replaced (i.e. {service}.{namespace}.apps.example.com).")
+	fs.DurationVar(&c.ResyncInterval, "resync-interval", c.ResyncInterval, "Resync with kubernetes master every user-defined interval.")
+	fs.BoolVar(&c.Once, "once", c.Once, "List resources once, render and check nginx.conf without reloading nginx, and exit.")
+	fs.DurationVar(&c.RenderQuietPeriod, "render-quiet-period", c.RenderQuietPeriod, "Wait for this period without changes before rendering.")
+	fs.DurationVar(&c.RenderMaxDelay, "render-max-delay", c.RenderMaxDelay, "Render at most this long after the first pending change.")
+	fs.DurationVar(&c.ReloadMinInterval, "reload-min-interval", c.ReloadMinInterval, "Minimum interval between consecutive renders and reloads.")
+	fs.StringVar(&c.AnnotationsPrefix, "annotations-prefix", c.AnnotationsPrefix, "Prefix of the annotations read from services and ingresses.")
+	fs.StringSliceVar(&c.AllowedDirectives, "allowed-directives", c.AllowedDirectives, "nginx directives which can be set through annotations.")
+	fs.BoolVar(&c.PublishStatus, "publish-status", c.PublishStatus, "Publish the address nginx is reachable at into the status of ingresses.")
+	fs.StringVar(&c.PublishAddress, "publish-address", c.PublishAddress, "IP or hostname to publish, the address of the node in NODE_NAME if not set.")
+	fs.StringVar(&c.ListenAddress, "listen-address", c.ListenAddress, "Address to serve metrics and health checks on, empty to disable it.")
+	fs.DurationVar(&c.HealthzTimeout, "healthz-timeout", c.HealthzTimeout, "Report unhealthy if the kubernetes master wasn't reached within this interval.")
+	fs.StringVar(&c.NginxSrc, "nginx-src", c.NginxSrc, "nginx.conf template file path.")
+	fs.StringVar(&c.NginxDest, "nginx-dst", c.NginxDest, "nginx.conf destination file path.")
+	fs.IntVar(&c.NginxDestUid, "nginx-dst-uid", c.NginxDestUid, "nginx.conf destination file uid.")
+	fs.IntVar(&c.NginxDestGid, "nginx-dst-gid", c.NginxDestGid, "nginx.conf destination file gid.")
+	fs.StringVar(&c.NginxDestMode, "nginx-dst-mode", c.NginxDestMode, "nginx.conf destination file mode.")
+	fs.StringVar(&c.NginxCheckCmd, "nginx-check-cmd", c.NginxCheckCmd, "nginx check command.")
+	fs.StringVar(&c.NginxReloadCmd, "nginx-reload-cmd", c.NginxReloadCmd, "nginx reload command.")
+	fs.StringVar(&c.NginxTemplates, "nginx-templates", c.NginxTemplates, "If present, YAML or JSON file listing additional templates to render along with nginx.conf.")
+	fs.BoolVar(&c.NginxSupervise, "nginx-supervise", c.NginxSupervise, "Run nginx as a child process, reloaded through signals instead of the reload command.")
+	fs.StringVar(&c.NginxBin, "nginx-bin", c.NginxBin, "nginx binary path, used when supervising it.")
+	fs.DurationVar(&c.NginxShutdownTimeout, "nginx-shutdown-timeout", c.NginxShutdownTimeout, "Time given to the supervised nginx to gracefully shut down before killing it.")
+	fs.IntVar(&c.NginxDiffMaxSize, "nginx-diff-max-size", c.NginxDiffMaxSize, "Maximum bytes of nginx.conf changes to log, 0 disables it.")
+	fs.StringVar(&c.NginxChangelog, "nginx-changelog", c.NginxChangelog, "If present, file where nginx.conf changes are appended to.")
 }
 
-func main() {
-	// configuration
-	cfg := pkg.NewConfig()
+func AddRenderConfigFlags(fs *flag.FlagSet, c *pkg.RenderConfig) {
+	fs.StringVar(&c.IngressesData, "ingresses-data", c.IngressesData, "Ingresses data.")
+	fs.StringVar(&c.IngressesFile, "ingresses-file", c.IngressesFile, "Ingresses data JSON or YAML file path.")
+	fs.BoolVar(&c.ValidateIngressesData, "validate-ingresses-data", c.ValidateIngressesData, "Reject ingresses data not following the layout of the default template.")
+	fs.StringVar(&c.ServicesFile, "services-file", c.ServicesFile, "Services list JSON file path.")
+	fs.StringVar(&c.EndpointsFile, "endpoints-file", c.EndpointsFile, "Endpoints list JSON file path.")
+	fs.BoolVar(&c.UseEndpoints, "use-endpoints", c.UseEndpoints, "Route to pod endpoints instead of the service cluster IP.")
+	fs.BoolVar(&c.ExposeAnnotatedOnly, "expose-annotated-only", c.ExposeAnnotatedOnly, "Only export services annotated with <annotations-prefix>/expose: \"true\".")
+	fs.StringVar(&c.ServiceHostPattern, "service-host-pattern", c.ServiceHostPattern, "If present, host given to exposed services without a <annotations-prefix>/hostname annotation, {service} and {namespace} are replaced.")
+	fs.StringVar(&c.AnnotationsPrefix, "annotations-prefix", c.AnnotationsPrefix, "Prefix of the annotations read from services.")
+	fs.StringVar(&c.Namespace, "namespace", c.Namespace, "Namespaces the resources belong to, comma-separated.")
+	fs.BoolVar(&c.AllNamespaces, "all-namespaces", c.AllNamespaces, "Resources belong to any namespace.")
+	fs.StringVar(&c.CertsDir, "certs-dir", c.CertsDir, "Directory the default template reads TLS certificates and dhparam.pem from.")
+	fs.StringVar(&c.NginxSrc, "nginx-src", c.NginxSrc, "nginx.conf template file path.")
+	fs.StringVarP(&c.Output, "output", "o", c.Output, "Rendered nginx.conf file path, '-' for stdout.")
+}
 
-	// flags
-	fs := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
-	fs.StringVar(&cfg.IngressesData, "ingresses-data", cfg.IngressesData, "Ingresses data.")
-	fs.StringVar(&cfg.KubeMasterURL, "kube-master-url", cfg.KubeMasterURL, "URL to reach kubernetes master.")
-	fs.StringVar(&cfg.Namespace, "namespace", cfg.Namespace, "If present, the namespace scope.")
-	fs.StringVar(&cfg.Selector, "selector", cfg.Selector, "Filter resources by a user-provided selector.")
-	fs.DurationVar(&cfg.ResyncInterval, "resync-interval", cfg.ResyncInterval, "Resync with kubernetes master every user-defined interval.")
-	fs.StringVar(&cfg.NginxSrc, "nginx-src", cfg.NginxSrc, "nginx.conf template file path.")
-	fs.StringVar(&cfg.NginxDest, "nginx-dst", cfg.NginxDest, "nginx.conf destination file path.")
-	fs.IntVar(&cfg.NginxDestUid, "nginx-dst-uid", cfg.NginxDestUid, "nginx.conf destination file uid.")
-	fs.IntVar(&cfg.NginxDestGid, "nginx-dst-gid", cfg.NginxDestGid, "nginx.conf destination file gid.")
-	fs.StringVar(&cfg.NginxDestMode, "nginx-dst-mode", cfg.NginxDestMode, "nginx.conf destination file mode.")
-	fs.StringVar(&cfg.NginxCheckCmd, "nginx-check-cmd", cfg.NginxCheckCmd, "nginx check command.")
-	fs.StringVar(&cfg.NginxReloadCmd, "nginx-reload-cmd", cfg.NginxReloadCmd, "nginx reload command.")
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
 	fs.SetNormalizeFunc(
 		func(f *flag.FlagSet, name string) flag.NormalizedName {
 			if strings.Contains(name, "_") {
@@ -42,9 +86,12 @@ func main() {
 			return flag.NormalizedName(name)
 		},
 	)
+	return fs
+}
 
+func parseFlags(fs *flag.FlagSet, args []string) {
 	// parse
-	fs.Parse(os.Args[1:])
+	fs.Parse(args)
 
 	// set from env (if present)
 	fs.VisitAll(func(f *flag.Flag) {
@@ -62,6 +109,37 @@ func main() {
 			}
 		}
 	})
+}
+
+func render(args []string) {
+	// configuration
+	cfg := pkg.NewRenderConfig()
+
+	// flags
+	fs := newFlagSet(os.Args[0] + " render")
+	AddRenderConfigFlags(fs, cfg)
+	parseFlags(fs, args)
+
+	// and then, render!
+	if err := pkg.Render(cfg); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "render" {
+		render(os.Args[2:])
+		return
+	}
+
+	// configuration
+	cfg := pkg.NewConfig()
+
+	// flags
+	fs := newFlagSet(os.Args[0])
+	AddConfigFlags(fs, cfg)
+	parseFlags(fs, os.Args[1:])
 
 	// and then, run!
 	k2n := pkg.NewKubeToNginx(cfg)
@@ -0,0 +1,121 @@
+package pkg
+
+import (
+    "encoding/json"
+    "fmt"
+    "regexp"
+    "sort"
+    "strconv"
+
+    log "github.com/glerchundi/logrus"
+    kapi "github.com/glerchundi/kubelistener/pkg/client/api/v1"
+)
+
+const (
+    // applied to every location of an ingress or pointing to a service
+    locationDirectivesAnnotation = "location-directives"
+    // applied to every host of an ingress
+    hostDirectivesAnnotation = "host-directives"
+    // marks services to export when only annotated ones are
+    exposeAnnotation = "expose"
+    // virtual host defined by a service
+    hostnameAnnotation = "hostname"
+    pathAnnotation = "path"
+    protocolAnnotation = "protocol"
+    secretAnnotation = "secret"
+)
+
+// directiveValueRegexp matches the directive values accepted from
+// annotations: words separated by single spaces, made of characters nginx
+// doesn't give a special meaning to. Quotes, escapes, comments, variables
+// and block or directive delimiters are rejected so that annotations can't
+// alter anything else in the config.
+var directiveValueRegexp = regexp.MustCompile(`^[A-Za-z0-9_.,:/=+*@%~-]+( [A-Za-z0-9_.,:/=+*@%~-]+)*$`)
+
+// getAnnotation returns the value of the named annotation under the
+// configured prefix, if present.
+func (k2n *KubeToNginx) getAnnotation(annotations map[string]string, name string) (string, bool) {
+    v, ok := annotations[fmt.Sprintf("%s/%s", k2n.config.AnnotationsPrefix, name)]
+    return v, ok
+}
+
+// isExposed reports whether the service has to be exported into upstreams,
+// either all of them are or only those annotated as such. Annotations which
+// aren't booleans don't expose them.
+func (k2n *KubeToNginx) isExposed(s kapi.Service) bool {
+    if !k2n.config.ExposeAnnotatedOnly {
+        return true
+    }
+
+    v, _ := k2n.getAnnotation(s.Annotations, exposeAnnotation)
+    exposed, _ := strconv.ParseBool(v)
+    return exposed
+}
+
+// getDirectives parses a directives annotation, a JSON object mapping nginx
+// directives to their values. Directives which aren't allowed, or whose
+// values could break out of the directive, are left out with a warning.
+func (k2n *KubeToNginx) getDirectives(owner string, annotations map[string]string, name string) map[string]string {
+    v, ok := k2n.getAnnotation(annotations, name)
+    if !ok {
+        return nil
+    }
+
+    var directives map[string]string
+    if err := json.Unmarshal([]byte(v), &directives); err != nil {
+        log.Warnf("%s: unable to parse %s annotation, ignoring it: %v", owner, name, err)
+        return nil
+    }
+
+    allowed := make(map[string]bool)
+    for _, directive := range k2n.config.AllowedDirectives {
+        allowed[directive] = true
+    }
+
+    for directive, value := range directives {
+        if !allowed[directive] {
+            log.Warnf("%s: directive %s is not allowed, ignoring it", owner, directive)
+            delete(directives, directive)
+        } else if !directiveValueRegexp.MatchString(value) {
+            log.Warnf("%s: directive %s has an invalid value %q, ignoring it", owner, directive, value)
+            delete(directives, directive)
+        }
+    }
+    return directives
+}
+
+// mergeDirectives adds the directives in src missing from dst, conflicting
+// ones are reported. It's used when several ingresses share a host.
+func mergeDirectives(owner string, dst, src map[string]string) map[string]string {
+    if dst == nil {
+        dst = make(map[string]string)
+    }
+
+    directives := make([]string, 0, len(src))
+    for directive := range src {
+        directives = append(directives, directive)
+    }
+    sort.Strings(directives)
+
+    for _, directive := range directives {
+        if v, ok := dst[directive]; ok {
+            if v != src[directive] {
+                log.Warnf("%s: directive %s is already set to %q, ignoring %q", owner, directive, v, src[directive])
+            }
+            continue
+        }
+        dst[directive] = src[directive]
+    }
+    return dst
+}
+
+// setWithDirectives sets a listener or location value, in the form which
+// carries its directives along if there is any.
+func setWithDirectives(kvs map[string]string, key, value string, directives map[string]string) {
+    if len(directives) == 0 {
+        kvs[key] = value
+        return
+    }
+    kvs[key+"/value"] = value
+    kvs[key+"/.nginx"] = toJson(directives)
+}
@@ -0,0 +1,161 @@
+package pkg
+
+import (
+    "bytes"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+
+    "github.com/glerchundi/kube2nginx/pkg/core"
+    log "github.com/glerchundi/logrus"
+    kapi "github.com/glerchundi/kubelistener/pkg/client/api/v1"
+)
+
+func (k2n *KubeToNginx) addSecret(s kapi.Secret) {
+    k2n.secrets[getObjectKey(s.Namespace, s.Name)] = s
+}
+
+func (k2n *KubeToNginx) deleteSecret(s kapi.Secret) {
+    key := getObjectKey(s.Namespace, s.Name)
+    _, ok := k2n.secrets[key]
+    if ok {
+        delete(k2n.secrets, key)
+    }
+}
+
+func (k2n *KubeToNginx) updateSecret(s kapi.Secret) {
+    k2n.secrets[getObjectKey(s.Namespace, s.Name)] = s
+}
+
+// syncCerts writes the certificate and key of every https listener which
+// references a secret into the certificates directory, the default template
+// expects them to be named after the host. It reports whether any file was
+// modified so that nginx can be reloaded even if its config didn't change.
+// Hosts whose certificates can't be synced don't stop the rest, they're
+// reported altogether in the returned error.
+func (k2n *KubeToNginx) syncCerts(kvs map[string]string) (bool, error) {
+    changed := false
+    var errs []string
+
+    secrets := getHostsSecrets(kvs)
+    hosts := make([]string, 0, len(secrets))
+    for host := range secrets {
+        hosts = append(hosts, host)
+    }
+    sort.Strings(hosts)
+
+    for _, host := range hosts {
+        ref := secrets[host]
+        if !strings.Contains(ref, "/") {
+            ref = getObjectKey(k2n.getNamespace(), ref)
+        }
+
+        s, ok := k2n.secrets[ref]
+        if !ok {
+            errs = append(errs, fmt.Sprintf("secret %s referenced by host %s not found", ref, host))
+            continue
+        }
+
+        if s.Type != core.SecretTypeTLS {
+            errs = append(errs, fmt.Sprintf("secret %s referenced by host %s is not of %s type", ref, host, core.SecretTypeTLS))
+            continue
+        }
+
+        files := []struct{
+            name string
+            data []byte
+            mode os.FileMode
+        }{
+            { fmt.Sprintf("%s.crt", host), s.Data[core.TLSCertKey], 0644 },
+            { fmt.Sprintf("%s.key", host), s.Data[core.TLSPrivateKeyKey], 0600 },
+        }
+        for _, f := range files {
+            path := filepath.Join(k2n.config.CertsDir, f.name)
+            written, err := writeFileIfChanged(path, f.data, f.mode)
+            if err != nil {
+                errs = append(errs, fmt.Sprintf("unable to write %s: %v", path, err))
+                continue
+            }
+            if written {
+                log.Infof("%s has been updated from secret %s", path, ref)
+                changed = true
+            }
+        }
+    }
+
+    if len(errs) > 0 {
+        return changed, errors.New(strings.Join(errs, "; "))
+    }
+    return changed, nil
+}
+
+// getHostsSecrets returns the secret referenced by each host through its
+// https listeners, either in the "namespace/name" or just "name" form.
+func getHostsSecrets(kvs map[string]string) map[string]string {
+    secrets := make(map[string]string)
+    for k, v := range kvs {
+        parts := strings.Split(strings.TrimPrefix(k, "/lb/hosts/"), "/")
+        if !strings.HasPrefix(k, "/lb/hosts/") || len(parts) < 3 || parts[1] != "listeners" {
+            continue
+        }
+        if len(parts) == 4 && parts[3] != "value" || len(parts) > 4 {
+            continue
+        }
+
+        var listener struct {
+            Protocol string `json:"protocol"`
+            Secret string `json:"secret"`
+        }
+        if err := json.Unmarshal([]byte(v), &listener); err != nil {
+            continue
+        }
+
+        if listener.Protocol == "https" && listener.Secret != "" {
+            secrets[parts[0]] = listener.Secret
+        }
+    }
+    return secrets
+}
+
+// writeFileIfChanged atomically replaces path with data, unless it already
+// holds the very same data and mode. It reports whether the file was written.
+func writeFileIfChanged(path string, data []byte, mode os.FileMode) (bool, error) {
+    if fi, err := os.Stat(path); err == nil && fi.Mode() == mode {
+        current, err := ioutil.ReadFile(path)
+        if err == nil && bytes.Equal(current, data) {
+            return false, nil
+        }
+    }
+
+    // create TempFile in the same directory to avoid cross-filesystem issues
+    tempFile, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+    if err != nil {
+        return false, err
+    }
+    defer os.Remove(tempFile.Name())
+
+    if err := tempFile.Chmod(mode); err != nil {
+        tempFile.Close()
+        return false, err
+    }
+
+    if _, err := tempFile.Write(data); err != nil {
+        tempFile.Close()
+        return false, err
+    }
+
+    if err := tempFile.Close(); err != nil {
+        return false, err
+    }
+
+    if err := os.Rename(tempFile.Name(), path); err != nil {
+        return false, err
+    }
+
+    return true, nil
+}
@@ -0,0 +1,38 @@
+package client
+
+import (
+    "math/rand"
+    "time"
+)
+
+const (
+    minBackoff = 500 * time.Millisecond
+    maxBackoff = 1 * time.Minute
+)
+
+// backoff computes exponentially growing delays between retries, a random
+// jitter of up to half the delay keeps several clients from retrying in
+// lockstep.
+type backoff struct {
+    current time.Duration
+}
+
+// next returns how long to wait before the next retry.
+func (b *backoff) next() time.Duration {
+    if b.current == 0 {
+        b.current = minBackoff
+    }
+
+    d := b.current
+    b.current *= 2
+    if b.current > maxBackoff {
+        b.current = maxBackoff
+    }
+
+    return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// reset starts over from the minimum delay, it's called after a success.
+func (b *backoff) reset() {
+    b.current = 0
+}
@@ -0,0 +1,864 @@
+// Package client lists and watches kubernetes resources. It's derived from
+// kubelistener's client, whose API types it still uses.
+package client
+
+import (
+    "bytes"
+    "crypto/tls"
+    "crypto/x509"
+    "encoding/base64"
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "net/http"
+    "net/url"
+    "os"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "time"
+
+    "github.com/gorilla/websocket"
+    "golang.org/x/net/context"
+    "golang.org/x/net/context/ctxhttp"
+    log "github.com/glerchundi/logrus"
+    "github.com/glerchundi/kubelistener/pkg/client/api/unversioned"
+    kapi "github.com/glerchundi/kubelistener/pkg/client/api/v1"
+    kruntime "github.com/glerchundi/kubelistener/pkg/client/runtime"
+)
+
+type Client struct {
+    // derived config
+    secure bool
+    tls *tls.Config
+    reqHeader http.Header
+    baseURL string
+    // user-provided configuration
+    config *ClientConfig
+}
+
+type ClientConfig struct {
+    MasterURL string
+    Auth ClientAuth
+    // CA certificate to verify the master with, system roots are used if
+    // not set. InsecureSkipVerify disables the verification altogether.
+    CaCertificate []byte
+    InsecureSkipVerify bool
+}
+
+type ClientAuth interface {
+}
+
+type ClientCertificateAuth struct {
+    ClientCertificate []byte
+    ClientKey []byte
+}
+
+type TokenAuth struct {
+    Token string
+}
+
+type UsernameAndPasswordAuth struct {
+    Username string
+    Password string
+}
+
+type Informer struct {
+    // number of items which couldn't be delivered on their own because
+    // recvChan was full and ended up coalesced into a snapshot, keep it first
+    // to guarantee 64-bit alignment for atomic operations
+    coalesced uint64
+    // number of relists forced because something might have been lost
+    relists uint64
+    // last successful interaction with the master, in unix nanoseconds
+    lastContact int64
+    // number of list and watch loops currently running
+    running int32
+    // derived config
+    httpClient *http.Client
+    httpReq *http.Request
+    wsURL string
+    wsDialer *websocket.Dialer
+    wsHeader http.Header
+    // user-provided configuration
+    config *InformerConfig
+    // inter-routine comm.
+    rc resourceCreator
+    recvChan chan<- interface{}
+    // last known state, guarded by mutex along with the delivery state: while
+    // a snapshot is pending no single item is delivered, dirty tells whether
+    // the store changed since the last snapshot was taken
+    mutex *sync.Mutex
+    store store
+    pending bool
+    dirty bool
+    // version watches are resumed from, guarded by mutex, empty until the
+    // first list or after it became too old
+    resourceVersion string
+    // signals the list loop to relist before the resync interval expires
+    relistChan chan struct{}
+    // signals the watch loop that a list just finished
+    listedChan chan struct{}
+    // control flow channels
+    stopChan <-chan struct{}
+    doneChan chan bool
+    errChan chan error
+}
+
+type InformerConfig struct {
+    Namespace string
+    AllNamespaces bool
+    Resource string
+    // label and field selectors narrowing down the resources to list and
+    // watch, empty to get all of them
+    Selector string
+    FieldSelector string
+    ResyncInterval time.Duration
+}
+
+type resourceCreator interface {
+    path() string
+    item() kruntime.Object
+    list() kruntime.Object
+}
+
+type podCreator struct {}
+func (*podCreator) path() string { return "api/v1" }
+func (*podCreator) item() kruntime.Object { return &kapi.Pod{} }
+func (*podCreator) list() kruntime.Object { return &kapi.PodList{} }
+
+type replicationControllerCreator struct {}
+func (*replicationControllerCreator) path() string { return "api/v1" }
+func (*replicationControllerCreator) item() kruntime.Object { return &kapi.ReplicationController{} }
+func (*replicationControllerCreator) list() kruntime.Object { return &kapi.ReplicationControllerList{} }
+
+type serviceCreator struct {}
+func (*serviceCreator) path() string { return "api/v1" }
+func (*serviceCreator) item() kruntime.Object { return &kapi.Service{} }
+func (*serviceCreator) list() kruntime.Object { return &kapi.ServiceList{} }
+
+type endpointsCreator struct {}
+func (*endpointsCreator) path() string { return "api/v1" }
+func (*endpointsCreator) item() kruntime.Object { return &kapi.Endpoints{} }
+func (*endpointsCreator) list() kruntime.Object { return &kapi.EndpointsList{} }
+
+type nodeCreator struct {}
+func (*nodeCreator) path() string { return "api/v1" }
+func (*nodeCreator) item() kruntime.Object { return &kapi.Node{} }
+func (*nodeCreator) list() kruntime.Object { return &kapi.NodeList{} }
+
+type secretCreator struct {}
+func (*secretCreator) path() string { return "api/v1" }
+func (*secretCreator) item() kruntime.Object { return &kapi.Secret{} }
+func (*secretCreator) list() kruntime.Object { return &kapi.SecretList{} }
+
+// funcCreator is used for resources registered from outside this package,
+// either from a named group (i.e. extensions) or from the legacy one.
+type funcCreator struct {
+    groupVersion string
+    itemFn func() kruntime.Object
+    listFn func() kruntime.Object
+}
+func (c *funcCreator) path() string {
+    if !strings.Contains(c.groupVersion, "/") {
+        return fmt.Sprintf("api/%s", c.groupVersion)
+    }
+    return fmt.Sprintf("apis/%s", c.groupVersion)
+}
+func (c *funcCreator) item() kruntime.Object { return c.itemFn() }
+func (c *funcCreator) list() kruntime.Object { return c.listFn() }
+
+var resourceCreatorMap = map[string]resourceCreator {
+    "pods": &podCreator{},
+    "replicationcontrollers": &replicationControllerCreator{},
+    "services": &serviceCreator{},
+    "endpoints": &endpointsCreator{},
+    "secrets": &secretCreator{},
+    "nodes": &nodeCreator{},
+}
+
+// RegisterResource makes a resource whose types are defined outside of this
+// package available to informers. groupVersion is in the form of
+// <group>/<version> (i.e. "extensions/v1beta1"), or just <version> for the
+// legacy group (i.e. "v1").
+func RegisterResource(resource, groupVersion string, item, list func() kruntime.Object) {
+    resourceCreatorMap[resource] = &funcCreator{groupVersion, item, list}
+}
+
+func copyHeader(hIn http.Header) http.Header {
+    hOut := make(http.Header, len(hIn))
+    for k, vv := range hIn {
+        vv2 := make([]string, len(vv))
+        copy(vv2, vv)
+        hOut[k] = vv2
+    }
+    return hOut
+}
+
+func NewClient(config *ClientConfig) (*Client, error) {
+    client := &Client{config: config}
+
+    masterURL := config.MasterURL
+    if masterURL == "" {
+        log.Warn("Master URL not set, discovering k8s service through env vars KUBERNETES_SERVICE{HOST,PORT}...")
+        k8sSvcHost := os.Getenv("KUBERNETES_SERVICE_HOST")
+        if k8sSvcHost == "" {
+            return nil, fmt.Errorf("empty KUBERNETES_SERVICE_HOST environment variable")
+        }
+
+        k8sSvcPort := os.Getenv("KUBERNETES_SERVICE_PORT")
+        if k8sSvcPort == "" {
+            return nil, fmt.Errorf("empty KUBERNETES_SERVICE_PORT environment variable")
+        }
+
+        masterURL = fmt.Sprintf("https://%s:%s", k8sSvcHost, k8sSvcPort)
+    }
+
+    url, err := url.Parse(masterURL)
+    if err != nil {
+        return nil, err
+    }
+
+    scheme := strings.ToLower(url.Scheme)
+    if scheme == "" || (scheme != "http" && scheme != "https") {
+        return nil, fmt.Errorf("invalid url scheme: '%s'", scheme)
+    }
+
+    // Secure endpoints always get a TLS config, verified against the system
+    // roots unless a CA certificate is provided
+    secure := scheme == "https"
+    client.secure = secure
+    if secure {
+        client.tls = &tls.Config{}
+
+        if client.config.CaCertificate != nil && client.config.InsecureSkipVerify {
+            return nil, fmt.Errorf("a CA certificate can't be combined with skipping TLS verification")
+        }
+
+        if client.config.CaCertificate != nil {
+            // Create CA certificate pool
+            pool := x509.NewCertPool()
+            if ok := pool.AppendCertsFromPEM([]byte(config.CaCertificate)); !ok {
+                return nil, fmt.Errorf("unable to load CA certificate")
+            }
+            client.tls.RootCAs = pool
+        }
+
+        if client.config.InsecureSkipVerify {
+            log.Warnf("TLS verification of %s is disabled, connections are insecure", masterURL)
+            client.tls.InsecureSkipVerify = true
+        }
+    }
+
+    // Load authentication parameters depending on the type
+    switch auth := client.config.Auth.(type) {
+    case *ClientCertificateAuth:
+        if !secure {
+            return nil, fmt.Errorf("client certificate requires using a secure endpoint")
+        }
+
+        cert, err := tls.X509KeyPair(auth.ClientCertificate, auth.ClientKey)
+        if err != nil {
+            return nil, fmt.Errorf("x509 client key pair could not be generated: %v", err)
+        }
+        client.tls.Certificates = []tls.Certificate{cert}
+    case *TokenAuth:
+        client.reqHeader = http.Header {
+            "Authorization": { fmt.Sprintf("Bearer %s", auth.Token) },
+        }
+    case *UsernameAndPasswordAuth:
+        encodedAuth := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s", auth.Username, auth.Password)))
+        client.reqHeader = http.Header {
+            "Authorization": { fmt.Sprintf("Basic %s", encodedAuth) },
+        }
+    default:
+        return nil, fmt.Errorf("unknown auth type: %v", auth)
+    }
+
+    if client.tls != nil {
+        client.tls.BuildNameToCertificate()
+    }
+
+    client.baseURL = url.Host
+
+    return client, nil
+}
+
+func (c *Client) NewInformer(config *InformerConfig, recvChan chan<- interface{},
+                             stopChan <-chan struct{}, doneChan chan bool, errChan chan error) (*Informer, error) {
+    // Check if a channel was provided
+    if recvChan == nil {
+        return nil, fmt.Errorf("no recv chan was provided")
+    }
+
+    // Use POD_NAMESPACE as default value or fallback to "default"
+    // unless resources from all namespaces were requested.
+    namespace := config.Namespace
+    if config.AllNamespaces {
+        namespace = ""
+    } else if namespace == "" {
+        namespace = os.Getenv("POD_NAMESPACE")
+        if namespace == "" {
+            namespace = "default"
+        }
+    }
+
+    resourceCreator, ok := resourceCreatorMap[config.Resource]
+    if !ok {
+        return nil, fmt.Errorf("'%s' is not a valid resource type", config.Resource)
+    }
+
+    // Selectors apply to both list and watch requests
+    query := url.Values{}
+    if config.Selector != "" {
+        query.Set("labelSelector", config.Selector)
+    }
+    if config.FieldSelector != "" {
+        query.Set("fieldSelector", config.FieldSelector)
+    }
+    querySuffix := ""
+    if len(query) > 0 {
+        querySuffix = "?" + query.Encode()
+    }
+
+    // HTTP Client
+    httpURL := c.getResourcesURL("http", resourceCreator.path(), namespace, config.Resource, false) + querySuffix
+    httpClient := &http.Client{
+        Transport: &http.Transport{
+            TLSClientConfig: c.tls,
+        },
+    }
+
+    httpReq, err := http.NewRequest("GET", httpURL, nil)
+    if err != nil {
+        return nil, fmt.Errorf("failed to create request: GET %s : %v", httpURL, err)
+    }
+    httpReq.Header = copyHeader(c.reqHeader)
+
+    // WebSocket Dialer
+    wsURL := c.getResourcesURL("ws", resourceCreator.path(), namespace, config.Resource, true) + querySuffix
+    wsDialer := &websocket.Dialer{
+        Proxy: http.ProxyFromEnvironment,
+        TLSClientConfig: c.tls,
+    }
+    wsHeader := copyHeader(c.reqHeader)
+    wsHeader.Add("Origin", "http://localhost")
+
+    // Return informer
+    return &Informer{
+        httpClient: httpClient,
+        httpReq: httpReq,
+        wsURL: wsURL,
+        wsDialer: wsDialer,
+        wsHeader: wsHeader,
+        config: config,
+        rc: resourceCreator,
+        recvChan: recvChan,
+        mutex: &sync.Mutex{},
+        store: make(store),
+        relistChan: make(chan struct{}, 1),
+        listedChan: make(chan struct{}, 1),
+        stopChan: stopChan,
+        doneChan: doneChan,
+        errChan: errChan,
+    }, nil
+}
+
+func (c *Client) getResourcesURL(schemePrefix, apiPath, namespace, resource string, watch bool) string {
+    // define scheme based on the master URL
+    scheme := schemePrefix
+    if c.secure {
+        scheme = fmt.Sprintf("%ss", schemePrefix)
+    }
+
+    // Add watch prefix if needed
+    watchPrefix := ""
+    if watch {
+        watchPrefix = "watch/"
+    }
+
+    // Add namespace scope if needed, cluster wide otherwise
+    namespacePrefix := ""
+    if namespace != "" {
+        namespacePrefix = fmt.Sprintf("namespaces/%s/", namespace)
+    }
+
+    // Return resources URL
+    return fmt.Sprintf("%s://%s/%s/%s%s%s", scheme, c.baseURL, apiPath, watchPrefix, namespacePrefix, resource)
+}
+
+// Get retrieves the named object into obj. The namespace must be empty for
+// cluster wide resources and subresource, if set, is appended to the object
+// path (i.e. "status").
+func (c *Client) Get(resource, namespace, name, subresource string, obj interface{}) error {
+    return c.do("GET", resource, namespace, name, subresource, nil, obj)
+}
+
+// Update replaces the named object with obj, the object returned by the
+// master is decoded into it.
+func (c *Client) Update(resource, namespace, name, subresource string, obj interface{}) error {
+    return c.do("PUT", resource, namespace, name, subresource, obj, obj)
+}
+
+func (c *Client) do(method, resource, namespace, name, subresource string, in, out interface{}) error {
+    resourceCreator, ok := resourceCreatorMap[resource]
+    if !ok {
+        return fmt.Errorf("'%s' is not a valid resource type", resource)
+    }
+
+    httpURL := fmt.Sprintf("%s/%s", c.getResourcesURL("http", resourceCreator.path(), namespace, resource, false), name)
+    if subresource != "" {
+        httpURL = fmt.Sprintf("%s/%s", httpURL, subresource)
+    }
+
+    var body []byte
+    if in != nil {
+        var err error
+        body, err = json.Marshal(in)
+        if err != nil {
+            return fmt.Errorf("failed to encode %s %s: %v", method, httpURL, err)
+        }
+    }
+
+    httpReq, err := http.NewRequest(method, httpURL, bytes.NewReader(body))
+    if err != nil {
+        return fmt.Errorf("failed to create request: %s %s : %v", method, httpURL, err)
+    }
+    httpReq.Header = copyHeader(c.reqHeader)
+    httpReq.Header.Set("Content-Type", "application/json")
+
+    httpClient := &http.Client{
+        Transport: &http.Transport{
+            TLSClientConfig: c.tls,
+        },
+    }
+
+    res, err := ctxhttp.Do(context.Background(), httpClient, httpReq)
+    if err != nil {
+        return fmt.Errorf("failed to make request: %s %s: %v", method, httpURL, err)
+    }
+
+    resBody, err := ioutil.ReadAll(res.Body)
+    res.Body.Close()
+    if err != nil {
+        return fmt.Errorf("failed to read request body for %s %s: %v", method, httpURL, err)
+    }
+
+    if res.StatusCode != http.StatusOK {
+        return fmt.Errorf("http error %d %s %q: %s", res.StatusCode, method, httpURL, string(resBody))
+    }
+
+    if out != nil {
+        if err := json.Unmarshal(resBody, out); err != nil {
+            return fmt.Errorf("failed to decode %s %s: %v", method, httpURL, err)
+        }
+    }
+
+    return nil
+}
+
+func (i *Informer) watch() {
+    const (
+        // Time allowed to write a message to the peer.
+        writeWait = 10 * time.Second
+        // Time allowed to read the next pong message from the peer.
+        pongWait = 10 * time.Second
+        // Send pings to peer with this period. Must be less than pongWait.
+        pingPeriod = (pongWait * 9) / 10
+    )
+
+    // write writes a message with the given message type and payload.
+    writeFn := func(ws *websocket.Conn, mt int, payload []byte) error {
+        ws.SetWriteDeadline(time.Now().Add(writeWait))
+        return ws.WriteMessage(mt, payload)
+    }
+
+    b := &backoff{}
+    for {
+        // watches start where the last list or event left
+        resourceVersion := i.getResourceVersion()
+        if resourceVersion == "" {
+            select {
+            case <-i.stopChan:
+                return
+            case <-i.listedChan:
+                continue
+            }
+        }
+
+        ws, resp, err := i.wsDialer.Dial(i.getWatchURL(resourceVersion), i.wsHeader)
+        if err != nil {
+            if err == websocket.ErrBadHandshake {
+                if resp.StatusCode == http.StatusGone {
+                    i.expire(resourceVersion)
+                    continue
+                }
+                err = fmt.Errorf("handshake failed with status %d", resp.StatusCode)
+            }
+            i.notifyError(err)
+            if !i.sleep(b.next()) {
+                return
+            }
+            continue
+        }
+        i.touch()
+
+        // TODO: Look which is the max resource limit in kubernetes (the json serialized one)
+        //ws.SetReadLimit(maxResourceSize)
+        ws.SetReadDeadline(time.Now().Add(pongWait))
+        ws.SetPongHandler(func(string) error {
+            i.touch()
+            ws.SetReadDeadline(time.Now().Add(pongWait)); return nil
+        })
+
+        // this routine pumps messages from the hub to the websocket connection.
+        go func() {
+            ticker := time.NewTicker(pingPeriod)
+            defer func() {
+                ticker.Stop()
+                ws.Close()
+            }()
+            for {
+                select {
+                case <-ticker.C:
+                    if err := writeFn(ws, websocket.PingMessage, []byte{}); err != nil {
+                        return
+                    }
+                }
+            }
+        }()
+
+        // watches closed by the master (i.e. timed out) or expired are resumed
+        // right away, only failures are retried with a backoff
+        failed := true
+        L: for {
+            select {
+            case <-i.stopChan:
+                ws.Close()
+                return
+            default:
+                we, err := i.readWatchEvent(ws)
+                if err != nil {
+                    if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+                        failed = false
+                    } else if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway) {
+                        i.notifyError(err)
+                    }
+                    break L
+                }
+                i.touch()
+
+                if we.Type == kapi.Error {
+                    status := we.Object.(*unversioned.Status)
+                    if status.Code == http.StatusGone {
+                        i.expire(resourceVersion)
+                        failed = false
+                        break L
+                    }
+                    i.notifyError(fmt.Errorf("watch failed with status %d: %s", status.Code, status.Message))
+                    break L
+                }
+
+                // notify watch event
+                b.reset()
+                if rv := objectResourceVersion(we.Object); rv != "" {
+                    resourceVersion = rv
+                    i.setResourceVersion(rv)
+                }
+                i.notify(we)
+            }
+        }
+        ws.Close()
+
+        if !failed {
+            b.reset()
+            continue
+        }
+
+        // don't redial in a tight loop if the watch keeps failing
+        if !i.sleep(b.next()) {
+            return
+        }
+    }
+}
+
+// readWatchEvent reads the next watch event, errors come along with a status
+// instead of an object.
+func (i *Informer) readWatchEvent(ws *websocket.Conn) (*kapi.WatchEvent, error) {
+    var raw struct {
+        Type kapi.EventType `json:"type"`
+        Object json.RawMessage `json:"object"`
+    }
+    if err := ws.ReadJSON(&raw); err != nil {
+        return nil, err
+    }
+
+    v := i.rc.item()
+    if raw.Type == kapi.Error {
+        v = &unversioned.Status{}
+    }
+    if err := json.Unmarshal(raw.Object, v); err != nil {
+        return nil, fmt.Errorf("failed to decode %s watch event: %v", i.config.Resource, err)
+    }
+
+    return &kapi.WatchEvent{Type: raw.Type, Object: v}, nil
+}
+
+// getWatchURL returns the watch URL resuming from the given version.
+func (i *Informer) getWatchURL(resourceVersion string) string {
+    u, err := url.Parse(i.wsURL)
+    if err != nil {
+        // built by ourselves, it can't happen
+        panic(err)
+    }
+    q := u.Query()
+    q.Set("resourceVersion", resourceVersion)
+    u.RawQuery = q.Encode()
+    return u.String()
+}
+
+// expire forgets a resource version which is too old to watch from, the
+// watch is resumed once a relist provides a new one.
+func (i *Informer) expire(resourceVersion string) {
+    i.mutex.Lock()
+    if i.resourceVersion == resourceVersion {
+        i.resourceVersion = ""
+    }
+    i.mutex.Unlock()
+
+    i.relist(fmt.Sprintf("resource version %s is gone", resourceVersion))
+}
+
+func (i *Informer) getResourceVersion() string {
+    i.mutex.Lock()
+    defer i.mutex.Unlock()
+    return i.resourceVersion
+}
+
+func (i *Informer) setResourceVersion(resourceVersion string) {
+    i.mutex.Lock()
+    defer i.mutex.Unlock()
+    i.resourceVersion = resourceVersion
+}
+
+// List performs a single list request, it's meant to be used without
+// running the informer.
+func (i *Informer) List() (interface{}, error) {
+    v, err := i.fetch()
+    if err != nil {
+        return nil, err
+    }
+    i.touch()
+    return v, nil
+}
+
+func (i *Informer) fetch() (interface{}, error) {
+    httpURL := i.httpReq.URL.String()
+    // requests are modified while being sent, don't share them between lists
+    httpReq := *i.httpReq
+    res, err := ctxhttp.Do(context.Background(), i.httpClient, &httpReq)
+    if err != nil {
+        return nil, fmt.Errorf("failed to make request: GET %s: %v", httpURL, err)
+    }
+
+    body, err := ioutil.ReadAll(res.Body)
+    res.Body.Close()
+    if err != nil {
+        return nil, fmt.Errorf("failed to read request body for GET %s: %v", httpURL, err)
+    }
+
+    if res.StatusCode != http.StatusOK {
+        return nil, fmt.Errorf("http error %d GET %q: %s: %v", res.StatusCode, httpURL, string(body), err)
+    }
+
+    v := i.rc.list()
+    if err := json.Unmarshal(body, &v); err != nil {
+        return nil, fmt.Errorf("failed to decode list of pod resources: %v", err)
+    }
+
+    return v, nil
+}
+
+func (i *Informer) list() {
+    b := &backoff{}
+    for {
+        v, err := i.fetch()
+        if err != nil {
+            i.notifyError(err)
+            if !i.sleep(b.next()) {
+                return
+            }
+            continue
+        }
+        b.reset()
+
+        // notify list
+        i.touch()
+        i.notify(v)
+
+        // let the watch resume from this list
+        if rv := listResourceVersion(v); rv != "" {
+            i.setResourceVersion(rv)
+        }
+        select {
+        case i.listedChan <- struct{}{}:
+        default:
+        }
+
+        // wait until resync or a relist is required
+        select {
+        case <-i.stopChan:
+            return
+        case <-i.relistChan:
+            continue
+        case <-time.After(i.config.ResyncInterval):
+            continue
+        }
+    }
+}
+
+// relist asks the list loop to list again right away, the result is
+// delivered as any other list.
+func (i *Informer) relist(reason string) {
+    select {
+    case i.relistChan <- struct{}{}:
+        atomic.AddUint64(&i.relists, 1)
+        log.Warnf("forcing a relist of %s: %s", i.config.Resource, reason)
+    default:
+        // already requested
+    }
+}
+
+// notify records v into the store and delivers it without blocking. If the
+// receiver isn't able to keep up, v and any item coming after it are
+// coalesced into a single list holding the whole known state, which is sent
+// as soon as the receiver is ready. Therefore receivers always end up with
+// the latest state, even if some intermediate events are never seen.
+func (i *Informer) notify(v interface{}) {
+    i.mutex.Lock()
+    defer i.mutex.Unlock()
+
+    i.store.apply(v)
+    if i.pending {
+        atomic.AddUint64(&i.coalesced, 1)
+        i.dirty = true
+        return
+    }
+
+    // send but do not block for it
+    select {
+    case i.recvChan <- v:
+        return
+    default:
+    }
+
+    atomic.AddUint64(&i.coalesced, 1)
+    log.Warnf("unable to notify %s item, a snapshot will be delivered instead", i.config.Resource)
+    i.pending = true
+    i.dirty = true
+    go i.deliverSnapshot()
+
+    // make sure the store isn't missing anything either
+    i.relist("receiver is not keeping up")
+}
+
+// deliverSnapshot blocks until a snapshot of the store is received, taking
+// a new one if it changed meanwhile. Then items are delivered again one by
+// one.
+func (i *Informer) deliverSnapshot() {
+    for {
+        i.mutex.Lock()
+        if !i.dirty {
+            i.pending = false
+            i.mutex.Unlock()
+            return
+        }
+        snapshot := i.store.snapshot(i.rc)
+        i.dirty = false
+        i.mutex.Unlock()
+
+        select {
+        case i.recvChan <- snapshot:
+        case <-i.stopChan:
+            return
+        }
+    }
+}
+
+func (i *Informer) touch() {
+    atomic.StoreInt64(&i.lastContact, time.Now().UnixNano())
+}
+
+// LastContact returns when the informer successfully talked to the master
+// for the last time, zero if it never did.
+func (i *Informer) LastContact() time.Time {
+    nsec := atomic.LoadInt64(&i.lastContact)
+    if nsec == 0 {
+        return time.Time{}
+    }
+    return time.Unix(0, nsec)
+}
+
+// Alive reports whether both list and watch loops are running.
+func (i *Informer) Alive() bool {
+    return atomic.LoadInt32(&i.running) == 2
+}
+
+// Coalesced returns the number of items which weren't delivered on their
+// own because the receiver wasn't able to keep up. None of them are lost,
+// they're delivered as part of a snapshot instead.
+func (i *Informer) Coalesced() uint64 {
+    return atomic.LoadUint64(&i.coalesced)
+}
+
+// Relists returns the number of relists forced so far because changes might
+// have been missed.
+func (i *Informer) Relists() uint64 {
+    return atomic.LoadUint64(&i.relists)
+}
+
+func (i *Informer) notifyError(err error) {
+    // send but do not block for it
+    select {
+    case i.errChan <- err:
+    default:
+        log.Warnf("unable to notify error, discarding it (%v)", err)
+    }
+}
+
+// sleep waits for d, it returns false if the informer was stopped meanwhile.
+func (i *Informer) sleep(d time.Duration) bool {
+    select {
+    case <-i.stopChan:
+        return false
+    case <-time.After(d):
+        return true
+    }
+}
+
+func (i *Informer) Run() {
+    defer close(i.doneChan)
+
+    var wg sync.WaitGroup
+
+    // watch through websocket endpoint
+    wg.Add(1)
+    go func() {
+        defer wg.Done()
+        atomic.AddInt32(&i.running, 1)
+        defer atomic.AddInt32(&i.running, -1)
+        i.watch()
+    }()
+
+    // list using http endpoint
+    wg.Add(1)
+    go func() {
+        defer wg.Done()
+        atomic.AddInt32(&i.running, 1)
+        defer atomic.AddInt32(&i.running, -1)
+        i.list()
+    }()
+
+    // wait until both finished
+    wg.Wait()
+}
\ No newline at end of file
@@ -0,0 +1,130 @@
+package client
+
+import (
+    "fmt"
+    "reflect"
+    "sort"
+
+    "github.com/glerchundi/kubelistener/pkg/client/api/unversioned"
+    kapi "github.com/glerchundi/kubelistener/pkg/client/api/v1"
+    kruntime "github.com/glerchundi/kubelistener/pkg/client/runtime"
+)
+
+// store keeps the last known state of every object seen by an informer,
+// keyed by <namespace>/<name>, so that a snapshot of it can be delivered
+// whenever single events can't.
+type store map[string]kruntime.Object
+
+// apply updates the store with either a list or a watch event.
+func (s store) apply(v interface{}) {
+    if we, ok := v.(*kapi.WatchEvent); ok {
+        key, ok := objectKey(we.Object)
+        if !ok {
+            return
+        }
+        switch we.Type {
+        case kapi.Added, kapi.Modified:
+            s[key] = we.Object
+        case kapi.Deleted:
+            delete(s, key)
+        }
+        return
+    }
+
+    items := listItems(v)
+    if !items.IsValid() {
+        return
+    }
+
+    for key := range s {
+        delete(s, key)
+    }
+    for n := 0; n < items.Len(); n++ {
+        o, ok := items.Index(n).Addr().Interface().(kruntime.Object)
+        if !ok {
+            continue
+        }
+        if key, ok := objectKey(o); ok {
+            s[key] = o
+        }
+    }
+}
+
+// snapshot returns a list, as created by rc, holding every stored object
+// sorted by key.
+func (s store) snapshot(rc resourceCreator) kruntime.Object {
+    list := rc.list()
+    items := listItems(list)
+    if !items.IsValid() {
+        return list
+    }
+
+    keys := make([]string, 0, len(s))
+    for key := range s {
+        keys = append(keys, key)
+    }
+    sort.Strings(keys)
+
+    slice := reflect.MakeSlice(items.Type(), 0, len(keys))
+    for _, key := range keys {
+        slice = reflect.Append(slice, reflect.ValueOf(s[key]).Elem())
+    }
+    items.Set(slice)
+
+    return list
+}
+
+// listItems returns the Items field of a list object, an invalid value if
+// it has none.
+func listItems(v interface{}) reflect.Value {
+    items := structField(v, "Items")
+    if items.Kind() != reflect.Slice {
+        return reflect.Value{}
+    }
+    return items
+}
+
+// objectKey returns <namespace>/<name> for any object embedding ObjectMeta.
+func objectKey(o kruntime.Object) (string, bool) {
+    meta, ok := objectMeta(o)
+    if !ok {
+        return "", false
+    }
+    return fmt.Sprintf("%s/%s", meta.Namespace, meta.Name), true
+}
+
+// objectResourceVersion returns the version of the object, empty if unknown.
+func objectResourceVersion(o kruntime.Object) string {
+    meta, _ := objectMeta(o)
+    return meta.ResourceVersion
+}
+
+// listResourceVersion returns the version of a list object, empty if
+// unknown.
+func listResourceVersion(v interface{}) string {
+    f := structField(v, "ListMeta")
+    if !f.IsValid() {
+        return ""
+    }
+    meta, _ := f.Interface().(unversioned.ListMeta)
+    return meta.ResourceVersion
+}
+
+func objectMeta(o kruntime.Object) (kapi.ObjectMeta, bool) {
+    f := structField(o, "ObjectMeta")
+    if !f.IsValid() {
+        return kapi.ObjectMeta{}, false
+    }
+    meta, ok := f.Interface().(kapi.ObjectMeta)
+    return meta, ok
+}
+
+// structField returns the named field of a pointer to struct, an invalid
+// value if there's no such field.
+func structField(v interface{}, name string) reflect.Value {
+    rv := reflect.ValueOf(v)
+    if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+        return reflect.Value{}
+    }
+    return rv.Elem().FieldByName(name)
+}
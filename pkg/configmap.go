@@ -0,0 +1,81 @@
+package pkg
+
+import (
+    "encoding/json"
+    "reflect"
+    "strings"
+
+    kclient "github.com/glerchundi/kube2nginx/pkg/client"
+    "github.com/glerchundi/kube2nginx/pkg/core"
+    log "github.com/glerchundi/logrus"
+    kruntime "github.com/glerchundi/kubelistener/pkg/client/runtime"
+)
+
+func init() {
+    // Config maps are missing from the vendored API types, they're defined
+    // next to ingresses.
+    kclient.RegisterResource(
+        "configmaps", "v1",
+        func() kruntime.Object { return &core.ConfigMap{} },
+        func() kruntime.Object { return &core.ConfigMapList{} },
+    )
+}
+
+// getIngressesConfigMap returns the namespace and name of the ingresses data
+// config map, given as "namespace/name" or just "name".
+func (k2n *KubeToNginx) getIngressesConfigMap() (string, string) {
+    ref := k2n.config.IngressesConfigMap
+    if i := strings.Index(ref, "/"); i >= 0 {
+        return ref[:i], ref[i+1:]
+    }
+    return k2n.getNamespace(), ref
+}
+
+// isIngressesConfigMap reports whether c is the ingresses data config map,
+// the only one listed and watched thanks to a field selector on its name.
+func (k2n *KubeToNginx) isIngressesConfigMap(c core.ConfigMap) bool {
+    namespace, name := k2n.getIngressesConfigMap()
+    return c.Namespace == namespace && c.Name == name
+}
+
+// setConfigMap parses the ingresses data held by the config map, the same
+// JSON map accepted by the ingresses data flag under the configured key. On
+// failure the last valid data is kept. It reports whether the data changed.
+func (k2n *KubeToNginx) setConfigMap(c core.ConfigMap) bool {
+    key := getObjectKey(c.Namespace, c.Name)
+    v, ok := c.Data[k2n.config.IngressesConfigMapKey]
+    if !ok {
+        log.Errorf("config map %s has no %s key, keeping the last valid data", key, k2n.config.IngressesConfigMapKey)
+        return false
+    }
+
+    data := make(map[string]string)
+    if err := json.Unmarshal([]byte(v), &data); err != nil {
+        log.Errorf("unable to parse config map %s, keeping the last valid data: %v", key, err)
+        return false
+    }
+
+    if err := k2n.validateIngressesData(data); err != nil {
+        log.Errorf("invalid config map %s, keeping the last valid data: %v", key, err)
+        return false
+    }
+
+    if k2n.configMapData != nil && reflect.DeepEqual(data, k2n.configMapData) {
+        return false
+    }
+
+    log.Infof("ingresses data loaded from config map %s", key)
+    k2n.configMapData = data
+    return true
+}
+
+// deleteConfigMap falls back to the ingresses data given through flags.
+func (k2n *KubeToNginx) deleteConfigMap(c core.ConfigMap) bool {
+    if k2n.configMapData == nil {
+        return false
+    }
+
+    log.Warnf("config map %s is gone, falling back to the ingresses data flags", getObjectKey(c.Namespace, c.Name))
+    k2n.configMapData = nil
+    return true
+}
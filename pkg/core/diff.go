@@ -0,0 +1,70 @@
+package core
+
+import (
+    "fmt"
+    "io/ioutil"
+    "os"
+    "time"
+
+    log "github.com/glerchundi/logrus"
+    "github.com/pmezard/go-difflib/difflib"
+)
+
+// diff returns a unified diff between the target config and the staged one,
+// a missing target is diffed as if it was empty.
+func (t *Template) diff(f *stagedFile) (string, error) {
+    var dest []byte
+    if isFileExist(f.dest) {
+        var err error
+        dest, err = ioutil.ReadFile(f.dest)
+        if err != nil {
+            return "", err
+        }
+    }
+
+    stage, err := ioutil.ReadFile(f.name)
+    if err != nil {
+        return "", err
+    }
+
+    return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+        A:        difflib.SplitLines(string(dest)),
+        B:        difflib.SplitLines(string(stage)),
+        FromFile: f.dest,
+        ToFile:   f.dest + " (staged)",
+        Context:  3,
+    })
+}
+
+// logDiff logs the given diff up to DiffMaxSize bytes, the rest is left out.
+func (t *Template) logDiff(dest, diff string) {
+    if t.config.DiffMaxSize <= 0 || diff == "" {
+        return
+    }
+
+    if len(diff) > t.config.DiffMaxSize {
+        truncated := len(diff) - t.config.DiffMaxSize
+        diff = fmt.Sprintf("%s\n... (%d bytes truncated)", diff[:t.config.DiffMaxSize], truncated)
+    }
+    log.Infof("Target config %s changes:\n%s", dest, diff)
+}
+
+// writeChangelog appends the whole diff to ChangelogFile, if set, so that
+// every applied change can be audited later on.
+func (t *Template) writeChangelog(diff string) {
+    if t.config.ChangelogFile == "" || diff == "" {
+        return
+    }
+
+    f, err := os.OpenFile(t.config.ChangelogFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+    if err != nil {
+        log.Errorf("unable to open changelog %s: %v", t.config.ChangelogFile, err)
+        return
+    }
+    defer f.Close()
+
+    _, err = fmt.Fprintf(f, "# %s\n%s\n", time.Now().UTC().Format(time.RFC3339), diff)
+    if err != nil {
+        log.Errorf("unable to write changelog %s: %v", t.config.ChangelogFile, err)
+    }
+}
@@ -0,0 +1,40 @@
+package core
+
+import (
+    "github.com/glerchundi/kube2nginx/pkg/metrics"
+)
+
+var (
+    rendersTotal = metrics.NewCounter(
+        "kube2nginx_renders_total",
+        "Number of template renders attempted.",
+    )
+    configChangesTotal = metrics.NewCounter(
+        "kube2nginx_config_changes_total",
+        "Number of times the rendered config differed from the target one.",
+    )
+    checkFailuresTotal = metrics.NewCounter(
+        "kube2nginx_check_failures_total",
+        "Number of times the check command failed.",
+    )
+    reloadFailuresTotal = metrics.NewCounter(
+        "kube2nginx_reload_failures_total",
+        "Number of times the reload command failed.",
+    )
+    reloadDuration = metrics.NewSummary(
+        "kube2nginx_reload_duration_seconds",
+        "Time spent running the reload command.",
+    )
+    rollbacksTotal = metrics.NewCounter(
+        "kube2nginx_rollbacks_total",
+        "Number of times the last known good config was restored after a failed reload.",
+    )
+    rolledBack = metrics.NewGauge(
+        "kube2nginx_config_rolled_back",
+        "Whether the target config is a rolled back one instead of the latest render.",
+    )
+    lastReloadTimestamp = metrics.NewGauge(
+        "kube2nginx_last_reload_success_timestamp_seconds",
+        "Unix time of the last successful reload.",
+    )
+)
@@ -41,8 +41,8 @@ events {
     listen {{.data.address}} ssl;
 
     ssl on;
-    ssl_certificate           /etc/nginx/certs/{{base .host}}.crt;
-    ssl_certificate_key       /etc/nginx/certs/{{base .host}}.key;
+    ssl_certificate           {{certsDir}}/{{base .host}}.crt;
+    ssl_certificate_key       {{certsDir}}/{{base .host}}.key;
 
     # enable session resumption to improve https performance
     # http://vincent.bernat.im/en/blog/2011-ssl-session-reuse-rfc5077.html and
@@ -50,8 +50,10 @@ events {
     ssl_session_cache         shared:SSL:10m;
     ssl_session_timeout       5m;
 
+  {{if fileExists (printf "%s/dhparam.pem" certsDir)}}
     # Diffie-Hellman parameter for DHE ciphersuites, recommended 2048 bits
-    ssl_dhparam               /etc/nginx/certs/dhparam.pem;
+    ssl_dhparam               {{certsDir}}/dhparam.pem;
+  {{end}}
 
     # enables server-side protection from BEAST attacks
     # http://blog.ivanristic.com/2013/09/is-beast-still-a-threat.html
@@ -68,7 +70,7 @@ events {
     # http://blog.mozilla.org/security/2013/07/29/ocsp-stapling-in-firefox/
     ssl_stapling on;
     ssl_stapling_verify off;
-    ssl_trusted_certificate /etc/nginx/certs/{{base .host}}.crt;
+    ssl_trusted_certificate {{certsDir}}/{{base .host}}.crt;
 
     # config to enable HSTS(HTTP Strict Transport Security) https://developer.mozilla.org/en-US/docs/Security/HTTP_Strict_Transport_Security
     # to avoid ssl stripping https://en.wikipedia.org/wiki/SSL_stripping#SSL_stripping
@@ -195,5 +197,28 @@ server {
     {{end}}
   {{end}}
 {{end}}
-}`
+}
+
+{{$streams := gets "/streams/*"}}{{if $streams}}
+stream {
+{{range $stream := $streams}}{{with json $stream.Value}}
+  {{$servers := gets (printf "/upstreams/%s/servers/*" .upstream)}}{{if $servers}}
+  upstream stream-{{base $stream.Key}} {
+  {{range $server := $servers}}{{with json .Value}}
+    server {{.url}};
+  {{end}}{{end}}
+  }
+
+  server {
+  {{if eq .protocol "udp"}}
+    listen {{.address}} udp;
+  {{else}}
+    listen {{.address}};
+  {{end}}
+    proxy_pass stream-{{base $stream.Key}};
+  }
+  {{end}}
+{{end}}{{end}}
+}
+{{end}}`
 )
\ No newline at end of file
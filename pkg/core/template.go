@@ -12,6 +12,8 @@ import (
     "os/exec"
     "path"
     "path/filepath"
+    "reflect"
+    "sort"
     "strconv"
     "strings"
     "text/template"
@@ -33,17 +35,62 @@ type TemplateConfig struct {
     Prefix        string
     CheckCmd      string
     ReloadCmd     string
+    Reload        func() error
+    DiffMaxSize   int
+    ChangelogFile string
+    CertsDir      string
+    Resources     []ResourceConfig
+}
+
+// ResourceConfig describes an additional file rendered along with the main
+// one, all of them share the same key/value pairs and are checked and
+// reloaded at once.
+type ResourceConfig struct {
+    Src     string
+    SrcData string
+    Dest    string
+    Uid     int
+    Gid     int
+    Mode    string
+    // Each, if set, renders one file per child of the given key, e.g.
+    // "/hosts". The "*" in Dest is replaced by the child name, which is
+    // passed to the template as ".".
+    Each    string
 }
 
 // Template is the representation of a parsed template resource.
 type Template struct {
     config        *TemplateConfig
+    resources     []ResourceConfig
     funcMap       map[string]interface{}
     store         memkv.Store
     doNoOp        bool
     keepStageFile bool
     useMutex      bool
     mutex         *sync.Mutex
+    forceSync     bool
+    lastGood      map[string]*savedFile
+    // destinations written by the last successful sync, the only ones
+    // which are removed once they're no longer rendered. They're recorded
+    // in a manifest next to Dest to survive restarts.
+    rendered      map[string]bool
+}
+
+// stagedFile is a rendered file waiting to replace its destination.
+type stagedFile struct {
+    name     string
+    resource *ResourceConfig
+    dest     string
+    mode     os.FileMode
+    diff     string
+}
+
+// savedFile is the content and permissions of a file to be restored.
+type savedFile struct {
+    data []byte
+    mode os.FileMode
+    uid  int
+    gid  int
 }
 
 func NewTemplate(config *TemplateConfig, doNoOp, keepStageFile, useMutex bool) *Template {
@@ -53,8 +100,27 @@ func NewTemplate(config *TemplateConfig, doNoOp, keepStageFile, useMutex bool) *
         funcMap[name] = fn
     }
 
+    // where templates find the TLS certificates of hosts
+    certsDir := config.CertsDir
+    if certsDir == "" {
+        certsDir = "/etc/nginx/certs"
+    }
+    funcMap["certsDir"] = func() string { return certsDir }
+
+    // the main resource always comes first
+    resources := []ResourceConfig{{
+        Src: config.Src,
+        SrcData: config.SrcData,
+        Dest: config.Dest,
+        Uid: config.Uid,
+        Gid: config.Gid,
+        Mode: config.Mode,
+    }}
+    resources = append(resources, config.Resources...)
+
     return &Template{
         config: config,
+        resources: resources,
         funcMap: funcMap,
         store: store,
         doNoOp: doNoOp,
@@ -66,46 +132,81 @@ func NewTemplate(config *TemplateConfig, doNoOp, keepStageFile, useMutex bool) *
 
 // Render is a convenience function that wraps calls to the three main
 // tasks required to keep local configuration files in sync. First we
-// stage a candidate configuration file, and finally sync things up.
-// It returns an error if any fails.
+// stage a candidate configuration file for every resource, and finally
+// sync things up. It returns an error if any fails.
 func (t *Template) Render(kvs map[string]string) error {
     t.mutex.Lock()
     defer t.mutex.Unlock()
 
-    fileMode, err := t.getExpectedFileMode()
-    if err != nil {
-        return err
-    }
+    rendersTotal.Inc()
 
     if err := t.setKVs(kvs); err != nil {
         return err
     }
 
-    stageFile, err := t.createStageFile(fileMode)
-    if err != nil {
-        return err
+    var staged []*stagedFile
+    defer func() {
+        if !t.keepStageFile {
+            for _, f := range staged {
+                os.Remove(f.name)
+            }
+        }
+    }()
+
+    for i := range t.resources {
+        r := &t.resources[i]
+        files, err := t.stage(r)
+        staged = append(staged, files...)
+        if err != nil {
+            return err
+        }
+    }
+
+    if t.rendered == nil {
+        t.rendered = t.loadManifest()
     }
 
-    if err := t.sync(stageFile, fileMode, t.doNoOp); err != nil {
+    if err := t.sync(staged, t.getStaleFiles(staged), t.doNoOp); err != nil {
         return err
     }
 
+    rendered := make(map[string]bool)
+    for _, f := range staged {
+        rendered[f.dest] = true
+    }
+    if !t.doNoOp && !reflect.DeepEqual(rendered, t.rendered) {
+        if err := t.saveManifest(rendered); err != nil {
+            log.Warnf("Unable to save %s: %v", t.getManifestPath(), err)
+        }
+    }
+    t.rendered = rendered
+
     return nil
 }
 
+// ForceSync makes the next Render check and reload the target config even if
+// it didn't change. Useful when files referenced by it, like certificates,
+// were modified.
+func (t *Template) ForceSync() {
+    t.mutex.Lock()
+    defer t.mutex.Unlock()
+
+    t.forceSync = true
+}
+
 // setFileMode sets the FileMode.
-func (t *Template) getExpectedFileMode() (os.FileMode, error) {
+func (t *Template) getExpectedFileMode(r *ResourceConfig, dest string) (os.FileMode, error) {
     var fileMode os.FileMode = 0644
-    if t.config.Mode == "" {
-        if isFileExist(t.config.Dest) {
-            fi, err := os.Stat(t.config.Dest)
+    if r.Mode == "" {
+        if isFileExist(dest) {
+            fi, err := os.Stat(dest)
             if err != nil {
                 return 0, err
             }
             fileMode = fi.Mode()
         }
     } else {
-        mode, err := strconv.ParseUint(t.config.Mode, 0, 32)
+        mode, err := strconv.ParseUint(r.Mode, 0, 32)
         if err != nil {
             return 0, err
         }
@@ -123,36 +224,90 @@ func (t *Template) setKVs(kvs map[string]string) error {
     return nil
 }
 
-// createStageFile stages the src configuration file by processing the src
-// template and setting the desired owner, group, and mode. It also sets the
-// StageFile for the template resource.
-// It returns an error if any.
-func (t *Template) createStageFile(fileMode os.FileMode) (*os.File, error) {
-    srcData := t.config.SrcData
-    if t.config.Src != "" {
-        log.Debugf("Using source template %s", t.config.Src)
+// Execute renders the main template with the given key/value pairs into w,
+// no file is staged nor synced.
+func (t *Template) Execute(kvs map[string]string, w io.Writer) error {
+    t.mutex.Lock()
+    defer t.mutex.Unlock()
 
-        if !isFileExist(t.config.Src) {
-            return nil, errors.New("Missing template: " + t.config.Src)
+    if err := t.setKVs(kvs); err != nil {
+        return err
+    }
+
+    tmpl, err := t.parse(&t.resources[0])
+    if err != nil {
+        return err
+    }
+
+    return tmpl.Execute(w, nil)
+}
+
+// parse compiles the src template, from file if set.
+func (t *Template) parse(r *ResourceConfig) (*template.Template, error) {
+    srcData := r.SrcData
+    if r.Src != "" {
+        log.Debugf("Using source template %s", r.Src)
+
+        if !isFileExist(r.Src) {
+            return nil, errors.New("Missing template: " + r.Src)
         }
 
-        fileData, err := ioutil.ReadFile(t.config.Src)
+        fileData, err := ioutil.ReadFile(r.Src)
         if err != nil {
             return nil, err
         }
 
         srcData = string(fileData)
-        log.Debugf("Compiling source template from file %s", t.config.Src)
+        log.Debugf("Compiling source template from file %s", r.Src)
+    }
+
+    tmpl, err := template.New(path.Base(r.Src)).Funcs(t.funcMap).Parse(srcData)
+    if err != nil {
+        return nil, fmt.Errorf("Unable to process template %s, %s", r.Src, err)
+    }
+
+    return tmpl, nil
+}
+
+// stage creates the stage files of a resource, one per child of its Each key
+// or just one if not set.
+func (t *Template) stage(r *ResourceConfig) ([]*stagedFile, error) {
+    tmpl, err := t.parse(r)
+    if err != nil {
+        return nil, err
+    }
+
+    if r.Each == "" {
+        f, err := t.createStageFile(tmpl, r, r.Dest, nil)
+        if err != nil {
+            return nil, err
+        }
+        return []*stagedFile{f}, nil
+    }
+
+    var files []*stagedFile
+    for _, name := range t.store.ListDir(r.Each) {
+        f, err := t.createStageFile(tmpl, r, strings.Replace(r.Dest, "*", name, 1), name)
+        if err != nil {
+            return files, err
+        }
+        files = append(files, f)
     }
+    return files, nil
+}
 
-    tmpl, err := template.New(path.Base(t.config.Src)).Funcs(t.funcMap).Parse(srcData)
+// createStageFile stages the src configuration file by processing the src
+// template and setting the desired owner, group, and mode.
+// It returns an error if any.
+func (t *Template) createStageFile(tmpl *template.Template, r *ResourceConfig, dest string, data interface{}) (*stagedFile, error) {
+    fileMode, err := t.getExpectedFileMode(r, dest)
     if err != nil {
-        return nil, fmt.Errorf("Unable to process template %s, %s", t.config.Src, err)
+        return nil, err
     }
 
     // create TempFile in Dest directory to avoid cross-filesystem issues
     errorOcurred := true
-    tempFile, err := ioutil.TempFile(filepath.Dir(t.config.Dest), "."+filepath.Base(t.config.Dest))
+    tempFile, err := ioutil.TempFile(filepath.Dir(dest), "."+filepath.Base(dest))
     if err != nil {
         return nil, err
     }
@@ -163,7 +318,7 @@ func (t *Template) createStageFile(fileMode os.FileMode) (*os.File, error) {
         }
     }()
 
-    if err = tmpl.Execute(tempFile, nil); err != nil {
+    if err = tmpl.Execute(tempFile, data); err != nil {
         return nil, err
     }
 
@@ -174,85 +329,346 @@ func (t *Template) createStageFile(fileMode os.FileMode) (*os.File, error) {
         return nil, err
     }
 
-    err = os.Chown(tempFile.Name(), t.config.Uid, t.config.Gid)
+    err = os.Chown(tempFile.Name(), r.Uid, r.Gid)
     if err != nil {
         return nil, err
     }
 
     errorOcurred = false
-    return tempFile, nil
+    return &stagedFile{
+        name: tempFile.Name(),
+        resource: r,
+        dest: dest,
+        mode: fileMode,
+    }, nil
 }
 
-// sync compares the staged and dest config files and attempts to sync them
-// if they differ. sync will run a config check command if set before
-// overwriting the target config file. Finally, sync will run a reload command
-// if set to have the application or service pick up the changes.
-// It returns an error if any.
-func (t *Template) sync(stageFile *os.File, fileMode os.FileMode, doNoOp bool) error {
-    stageFileName := stageFile.Name()
-    if !t.keepStageFile {
-        defer os.Remove(stageFileName)
+// getStaleFiles returns the files written by the last successful sync which
+// are no longer rendered, they're removed when syncing. Files found in the
+// destination directories but never written by us are left alone.
+func (t *Template) getStaleFiles(staged []*stagedFile) []string {
+    dests := make(map[string]bool)
+    for _, f := range staged {
+        dests[f.dest] = true
+    }
+
+    var stale []string
+    for dest := range t.rendered {
+        if !dests[dest] && isFileExist(dest) {
+            stale = append(stale, dest)
+        }
     }
+    sort.Strings(stale)
+    return stale
+}
 
-    log.Debugf("Comparing candidate config to %s", t.config.Dest)
-    ok, err := isSameConfig(stageFileName, t.config.Dest)
+// getManifestPath returns the file listing the destinations written by the
+// last successful sync.
+func (t *Template) getManifestPath() string {
+    return filepath.Join(filepath.Dir(t.config.Dest), "."+filepath.Base(t.config.Dest)+".rendered")
+}
+
+// loadManifest returns the destinations recorded by a previous process, so
+// that files it rendered which are no longer needed are removed as well.
+// Only destinations matching the ones of fanned out resources are trusted.
+func (t *Template) loadManifest() map[string]bool {
+    rendered := make(map[string]bool)
+    if t.doNoOp {
+        return rendered
+    }
+
+    data, err := ioutil.ReadFile(t.getManifestPath())
+    if err != nil {
+        if !os.IsNotExist(err) {
+            log.Warnf("Unable to read %s: %v", t.getManifestPath(), err)
+        }
+        return rendered
+    }
+
+    for _, dest := range strings.Split(string(data), "\n") {
+        for _, r := range t.resources {
+            if ok, _ := filepath.Match(r.Dest, dest); ok && r.Each != "" {
+                rendered[dest] = true
+            }
+        }
+    }
+    return rendered
+}
+
+// saveManifest atomically records the given destinations.
+func (t *Template) saveManifest(rendered map[string]bool) error {
+    dests := make([]string, 0, len(rendered))
+    for dest := range rendered {
+        dests = append(dests, dest)
+    }
+    sort.Strings(dests)
+
+    path := t.getManifestPath()
+    tempFile, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path))
+    if err != nil {
+        return err
+    }
+    defer os.Remove(tempFile.Name())
+
+    _, err = tempFile.WriteString(strings.Join(dests, "\n") + "\n")
+    if cerr := tempFile.Close(); err == nil {
+        err = cerr
+    }
     if err != nil {
-        log.Error(err)
         return err
     }
+    return os.Rename(tempFile.Name(), path)
+}
+
+// sync compares the staged and dest config files and attempts to sync them
+// if they differ. sync will run a config check command if set before
+// overwriting the target config files. Finally, sync will run a reload
+// command if set to have the application or service pick up the changes.
+//
+// With a single resource the check runs against its stage file. Otherwise
+// files referencing each other must be checked together, so they are put in
+// place first and restored if the check fails.
+// It returns an error if any.
+func (t *Template) sync(staged []*stagedFile, stale []string, doNoOp bool) error {
+    var changed []*stagedFile
+    for _, f := range staged {
+        log.Debugf("Comparing candidate config to %s", f.dest)
+        ok, err := isSameConfig(f.name, f.dest)
+        if err != nil {
+            log.Error(err)
+            return err
+        }
+        if !ok {
+            changed = append(changed, f)
+        }
+    }
 
     if doNoOp {
         log.Warnf("Noop mode enabled. %s will not be modified", t.config.Dest)
         return nil
     }
 
-    if !ok {
-        log.Infof("Target config %s out of sync", t.config.Dest)
+    if len(changed) == 0 && len(stale) == 0 && !t.forceSync {
+        log.Debugf("Target config %s in sync", t.config.Dest)
+        return nil
+    }
+
+    if len(changed) == 0 && len(stale) == 0 {
+        log.Infof("Target config %s forced to sync", t.config.Dest)
+    } else {
+        configChangesTotal.Inc()
+    }
+    for _, f := range changed {
+        log.Infof("Target config %s out of sync", f.dest)
+
+        diff, err := t.diff(f)
+        if err != nil {
+            log.Warnf("Unable to diff %s: %v", f.dest, err)
+        }
+        f.diff = diff
+        t.logDiff(f.dest, diff)
+    }
+    for _, dest := range stale {
+        log.Infof("Target config %s is no longer rendered", dest)
+    }
+
+    // the targets found on startup are assumed to be good, keep them around
+    // until a reload succeeds so that there is something to restore
+    if t.lastGood == nil {
+        lastGood, err := saveFiles(staged, stale)
+        if err != nil {
+            return err
+        }
+        t.lastGood = lastGood
+    }
 
+    if len(t.resources) == 1 {
         if t.config.CheckCmd != "" {
-            if err := t.check(stageFileName); err != nil {
+            if err := t.check(staged[0].name); err != nil {
+                checkFailuresTotal.Inc()
                 return errors.New("Config check failed: " + err.Error())
             }
         }
 
-        log.Debugf("Overwriting target config %s", t.config.Dest)
+        if err := t.install(changed, stale); err != nil {
+            return err
+        }
+    } else {
+        if err := t.install(changed, stale); err != nil {
+            t.restore(staged)
+            return err
+        }
 
-        err := os.Rename(stageFileName, t.config.Dest)
-        if err != nil {
-            if strings.Contains(err.Error(), "device or resource busy") {
-                log.Debugf("Rename failed - target is likely a mount.config. Trying to write instead")
-                // try to open the file and write to it
-                var contents []byte
-                var rerr error
-                contents, rerr = ioutil.ReadFile(stageFileName)
-                if rerr != nil {
-                    return rerr
-                }
-                err := ioutil.WriteFile(t.config.Dest, contents, fileMode)
-                // make sure owner and group match the temp file, in case the file was created with WriteFile
-                os.Chown(t.config.Dest, t.config.Uid, t.config.Gid)
-                if err != nil {
-                    return err
+        if t.config.CheckCmd != "" {
+            if err := t.check(t.config.Dest); err != nil {
+                checkFailuresTotal.Inc()
+                if rerr := t.restore(staged); rerr != nil {
+                    log.Errorf("Unable to restore the previous config: %v", rerr)
                 }
-            } else {
-                return err
+                return errors.New("Config check failed: " + err.Error())
             }
         }
+    }
 
-        if t.config.ReloadCmd != "" {
-            if err := t.reload(); err != nil {
+    if t.config.ReloadCmd != "" || t.config.Reload != nil {
+        if err := t.reload(); err != nil {
+            return t.rollback(staged, err)
+        }
+    }
+
+    lastGood, err := saveFiles(staged, nil)
+    if err != nil {
+        return err
+    }
+    t.lastGood = lastGood
+    rolledBack.Set(0)
+    for _, f := range changed {
+        t.writeChangelog(f.diff)
+    }
+
+    t.forceSync = false
+    log.Infof("Target config %s has been updated", t.config.Dest)
+
+    return nil
+}
+
+// install moves the changed stage files over their targets and removes the
+// stale ones.
+func (t *Template) install(changed []*stagedFile, stale []string) error {
+    for _, f := range changed {
+        log.Debugf("Overwriting target config %s", f.dest)
+        if err := replaceDest(f.name, f.dest, f.mode, f.resource.Uid, f.resource.Gid); err != nil {
+            return err
+        }
+    }
+
+    for _, dest := range stale {
+        log.Debugf("Removing target config %s", dest)
+        if err := os.Remove(dest); err != nil && !os.IsNotExist(err) {
+            return err
+        }
+    }
+
+    return nil
+}
+
+// replaceDest moves the staged file over the target config, falling back to
+// overwrite its contents if the target can't be replaced.
+func replaceDest(stageFileName, dest string, fileMode os.FileMode, uid, gid int) error {
+    err := os.Rename(stageFileName, dest)
+    if err != nil {
+        if strings.Contains(err.Error(), "device or resource busy") {
+            log.Debugf("Rename failed - target is likely a mount.config. Trying to write instead")
+            // try to open the file and write to it
+            var contents []byte
+            var rerr error
+            contents, rerr = ioutil.ReadFile(stageFileName)
+            if rerr != nil {
+                return rerr
+            }
+            err := ioutil.WriteFile(dest, contents, fileMode)
+            // make sure owner and group match the temp file, in case the file was created with WriteFile
+            os.Chown(dest, uid, gid)
+            if err != nil {
                 return err
             }
+        } else {
+            return err
         }
+    }
+    return nil
+}
 
-        log.Infof("Target config %s has been updated", t.config.Dest)
-    } else {
-        log.Debugf("Target config %s in sync", t.config.Dest)
+// saveFiles reads the targets of the staged files and the given extra ones,
+// missing files are left out.
+func saveFiles(staged []*stagedFile, extra []string) (map[string]*savedFile, error) {
+    dests := append([]string{}, extra...)
+    for _, f := range staged {
+        dests = append(dests, f.dest)
+    }
+
+    saved := make(map[string]*savedFile)
+    for _, dest := range dests {
+        if !isFileExist(dest) {
+            continue
+        }
+
+        data, err := ioutil.ReadFile(dest)
+        if err != nil {
+            return nil, err
+        }
+        fi, err := getFileInfo(dest)
+        if err != nil {
+            return nil, err
+        }
+        saved[dest] = &savedFile{data, fi.Mode, int(fi.Uid), int(fi.Gid)}
+    }
+    return saved, nil
+}
+
+// restore puts the last known good files back in place, the staged targets
+// which didn't exist back then are removed.
+func (t *Template) restore(staged []*stagedFile) error {
+    for dest, saved := range t.lastGood {
+        tempFile, err := ioutil.TempFile(filepath.Dir(dest), "."+filepath.Base(dest))
+        if err != nil {
+            return err
+        }
+
+        _, err = tempFile.Write(saved.data)
+        tempFile.Close()
+        if err == nil {
+            err = os.Chmod(tempFile.Name(), saved.mode)
+        }
+        if err == nil {
+            err = os.Chown(tempFile.Name(), saved.uid, saved.gid)
+        }
+        if err == nil {
+            err = replaceDest(tempFile.Name(), dest, saved.mode, saved.uid, saved.gid)
+        }
+        os.Remove(tempFile.Name())
+        if err != nil {
+            return err
+        }
+    }
+
+    for _, f := range staged {
+        if _, ok := t.lastGood[f.dest]; ok {
+            continue
+        }
+        if err := os.Remove(f.dest); err != nil && !os.IsNotExist(err) {
+            return err
+        }
     }
 
     return nil
 }
 
+// rollback restores the last known good config after reloadErr and reloads
+// again, so that the service keeps running with it and a restart doesn't
+// pick up a broken config. The returned error always reports the failed
+// reload, whether the rollback succeeded or not.
+func (t *Template) rollback(staged []*stagedFile, reloadErr error) error {
+    if _, ok := t.lastGood[t.config.Dest]; !ok {
+        return fmt.Errorf("Reload failed and there is no known good config to roll back to: %v", reloadErr)
+    }
+
+    log.Warnf("Reload failed, rolling back %s to the last known good config", t.config.Dest)
+    rollbacksTotal.Inc()
+
+    err := t.restore(staged)
+    if err == nil {
+        err = t.reload()
+    }
+    if err != nil {
+        return fmt.Errorf("Reload failed: %v, unable to roll back: %v", reloadErr, err)
+    }
+
+    rolledBack.Set(1)
+    log.Warnf("Target config %s has been rolled back", t.config.Dest)
+    return fmt.Errorf("Reload failed, rolled back to the last known good config: %v", reloadErr)
+}
+
 // check executes the check command to validate the staged config file. The
 // command is modified so that any references to src template are substituted
 // with a string representing the full path of the staged file. This allows the
@@ -273,10 +689,24 @@ func (t *Template) check(stageFileName string) error {
     return t.exec(cmdBuffer.String())
 }
 
-// reload executes the reload command.
+// reload executes the reload function if set, the reload command otherwise.
 // It returns nil if the reload command returns 0.
 func (t *Template) reload() error {
-    return t.exec(t.config.ReloadCmd)
+    start := time.Now()
+    var err error
+    if t.config.Reload != nil {
+        err = t.config.Reload()
+    } else {
+        err = t.exec(t.config.ReloadCmd)
+    }
+    reloadDuration.ObserveSince(start)
+    if err != nil {
+        reloadFailuresTotal.Inc()
+        return err
+    }
+
+    lastReloadTimestamp.SetToCurrentTime()
+    return nil
 }
 
 func (t *Template) exec(cmd string) error {
@@ -386,9 +816,16 @@ func newFuncMap() map[string]interface{} {
     m["toLower"] = strings.ToLower
     m["contains"] = strings.Contains
     m["replace"] = strings.Replace
+    m["fileExists"] = fileExists
     return m
 }
 
+// fileExists reports whether path is an existing regular file.
+func fileExists(path string) bool {
+    fi, err := os.Stat(path)
+    return err == nil && fi.Mode().IsRegular()
+}
+
 func UnmarshalJsonObject(data string) (map[string]interface{}, error) {
     var ret map[string]interface{}
     err := json.Unmarshal([]byte(data), &ret)
@@ -0,0 +1,108 @@
+package core
+
+import (
+    "errors"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "reflect"
+    "testing"
+)
+
+func TestTemplateRenderRestoresOnFailure(t *testing.T) {
+    tests := []struct {
+        name string
+        fanOut bool
+        checkCmd string
+        failReload bool
+    }{
+        {"check failure with a single resource", false, "false", false},
+        {"reload failure with a single resource", false, "", true},
+        {"check failure with several resources", true, "false", false},
+        {"reload failure with several resources", true, "", true},
+    }
+
+    for _, test := range tests {
+        dir, err := ioutil.TempDir("", "template")
+        if err != nil {
+            t.Fatal(err)
+        }
+        defer os.RemoveAll(dir)
+
+        reloads := 0
+        config := &TemplateConfig{
+            SrcData: `{{range getvs "/hosts/*/value"}}{{.}};{{end}}`,
+            Dest: filepath.Join(dir, "nginx.conf"),
+            Uid: os.Getuid(),
+            Gid: os.Getgid(),
+            Mode: "0644",
+            Prefix: "/lb",
+            Reload: func() error {
+                reloads++
+                if test.failReload && reloads == 2 {
+                    return errors.New("reload failed")
+                }
+                return nil
+            },
+        }
+        if test.fanOut {
+            if err := os.Mkdir(filepath.Join(dir, "hosts"), 0755); err != nil {
+                t.Fatal(err)
+            }
+            config.Resources = []ResourceConfig{{
+                SrcData: `{{getv (printf "/hosts/%s/value" .)}}`,
+                Dest: filepath.Join(dir, "hosts", "*.conf"),
+                Uid: os.Getuid(),
+                Gid: os.Getgid(),
+                Mode: "0644",
+                Each: "/hosts",
+            }}
+        }
+        tmpl := NewTemplate(config, false, false, false)
+
+        good := map[string]string{
+            "/lb/hosts/a/value": "a1",
+            "/lb/hosts/b/value": "b1",
+        }
+        if err := tmpl.Render(good); err != nil {
+            t.Fatalf("%s: unexpected error: %v", test.name, err)
+        }
+        expected := readFiles(t, dir)
+
+        // a changes, b is removed and c is added
+        config.CheckCmd = test.checkCmd
+        bad := map[string]string{
+            "/lb/hosts/a/value": "a2",
+            "/lb/hosts/c/value": "c2",
+        }
+        if err := tmpl.Render(bad); err == nil {
+            t.Errorf("%s: expected an error", test.name)
+        }
+
+        if files := readFiles(t, dir); !reflect.DeepEqual(files, expected) {
+            t.Errorf("%s: expected %v to be restored, got %v", test.name, expected, files)
+        }
+    }
+}
+
+// readFiles returns the contents of the regular files in dir and its
+// subdirectories, by path relative to dir. Hidden files are left out.
+func readFiles(t *testing.T, dir string) map[string]string {
+    files := make(map[string]string)
+    err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+        if err != nil || fi.IsDir() || filepath.Base(path)[0] == '.' {
+            return err
+        }
+        data, err := ioutil.ReadFile(path)
+        if err != nil {
+            return err
+        }
+        rel, _ := filepath.Rel(dir, path)
+        files[rel] = string(data)
+        return nil
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    return files
+}
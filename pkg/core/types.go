@@ -42,12 +42,29 @@ type IngressSpec struct {
     // is optional to allow the loadbalancer controller or defaulting logic to
     // specify a global default.
     Backend *IngressBackend `json:"backend,omitempty"`
+    // TLS configuration. Currently the Ingress only supports a single TLS
+    // port, 443, and assumes TLS termination. If multiple members of this
+    // list specify different hosts, they will be multiplexed on the same
+    // port according to the hostname specified through the SNI TLS extension.
+    TLS []IngressTLS `json:"tls,omitempty"`
     // A list of host rules used to configure the Ingress. If unspecified, or
     // no rule matches, all traffic is sent to the default backend.
     Rules []IngressRule `json:"rules,omitempty"`
     // TODO: Add the ability to specify load-balancer IP through claims
 }
 
+// IngressTLS describes the transport layer security associated with an Ingress.
+type IngressTLS struct {
+    // Hosts are a list of hosts included in the TLS certificate. The values in
+    // this list must match the name/s used in the tlsSecret. Defaults to the
+    // wildcard host setting for the loadbalancer controller fulfilling this
+    // Ingress, if left unspecified.
+    Hosts []string `json:"hosts,omitempty"`
+    // SecretName is the name of the secret used to terminate SSL traffic on
+    // 443. The secret must live in the same namespace as the Ingress.
+    SecretName string `json:"secretName,omitempty"`
+}
+
 // IngressStatus describe the current state of the Ingress.
 type IngressStatus struct {
     // LoadBalancer contains the current status of the load-balancer.
@@ -129,4 +146,44 @@ type IngressBackend struct {
 
     // Specifies the port of the referenced service.
     ServicePort v1.IntOrString `json:"servicePort"`
-}
\ No newline at end of file
+}
+
+func (*Ingress) IsAnAPIObject()     {}
+func (*IngressList) IsAnAPIObject() {}
+// ConfigMap holds configuration data for components or applications to consume.
+type ConfigMap struct {
+    unversioned.TypeMeta `json:",inline"`
+    // Standard object's metadata.
+    // More info: http://releases.k8s.io/HEAD/docs/devel/api-conventions.md#metadata
+    v1.ObjectMeta `json:"metadata,omitempty"`
+
+    // Data contains the configuration data.
+    // Each key must be a valid DNS_SUBDOMAIN with an optional leading dot.
+    Data map[string]string `json:"data,omitempty"`
+}
+
+// ConfigMapList is a resource containing a list of ConfigMap objects.
+type ConfigMapList struct {
+    unversioned.TypeMeta `json:",inline"`
+    // Standard list metadata.
+    // More info: http://releases.k8s.io/HEAD/docs/devel/api-conventions.md#metadata
+    unversioned.ListMeta `json:"metadata,omitempty"`
+
+    // Items is the list of ConfigMaps.
+    Items []ConfigMap `json:"items"`
+}
+
+func (*ConfigMap) IsAnAPIObject()     {}
+func (*ConfigMapList) IsAnAPIObject() {}
+
+const (
+    // SecretTypeTLS contains information about a TLS client or server secret.
+    // It is primarily used with TLS termination of the Ingress resource, but
+    // may be used in other types.
+    SecretTypeTLS v1.SecretType = "kubernetes.io/tls"
+
+    // TLSCertKey is the key for tls certificates in a TLS secret.
+    TLSCertKey = "tls.crt"
+    // TLSPrivateKeyKey is the key for the private key field in a TLS secret.
+    TLSPrivateKeyKey = "tls.key"
+)
@@ -0,0 +1,197 @@
+package core
+
+import (
+    "encoding/json"
+    "fmt"
+    "sort"
+    "strings"
+)
+
+// ValidationErrors lists every problem found in a set of key/value pairs.
+type ValidationErrors []string
+
+func (e ValidationErrors) Error() string {
+    return strings.Join(e, "; ")
+}
+
+// Validate checks that the key/value pairs follow the layout the default
+// template understands, keys are relative to prefix (i.e. "/lb"):
+//
+//   <prefix>/settings[/.nginx]                     {...}
+//   <prefix>/hosts/<host>/listeners/<name>         {"protocol","address"[,"secret"]}
+//   <prefix>/hosts/<host>/locations/<name>         {"path","upstream"}
+//   <prefix>/upstreams/<name>/servers/<server>     {"url"}
+//   <prefix>/streams/<name>                        {"protocol","address","upstream"}
+//
+// Listeners and locations can also be given as "<key>/value" along with
+// "<key>/.nginx", a map of extra directives. It returns nil or a
+// ValidationErrors with one entry per offending key.
+func Validate(kvs map[string]string, prefix string) error {
+    keys := make([]string, 0, len(kvs))
+    for k := range kvs {
+        keys = append(keys, k)
+    }
+    sort.Strings(keys)
+
+    var errs ValidationErrors
+    for _, k := range keys {
+        if err := validateKV(strings.TrimPrefix(k, prefix), kvs[k]); err != nil {
+            errs = append(errs, fmt.Sprintf("%s: %v", k, err))
+        }
+    }
+
+    if len(errs) > 0 {
+        return errs
+    }
+    return nil
+}
+
+func validateKV(k, v string) error {
+    parts := strings.Split(strings.TrimPrefix(k, "/"), "/")
+    switch parts[0] {
+    case "settings":
+        switch {
+        case len(parts) == 1, len(parts) == 2 && parts[1] == ".nginx":
+            return validateObject(v)
+        }
+        return fmt.Errorf("unknown key, expected settings or settings/.nginx")
+    case "hosts":
+        if len(parts) < 4 || parts[1] == "" || parts[3] == "" {
+            return fmt.Errorf("unknown key, expected hosts/<host>/{listeners,locations}/<name>")
+        }
+
+        var validate func(string) error
+        switch parts[2] {
+        case "listeners":
+            validate = validateListener
+        case "locations":
+            validate = validateLocation
+        default:
+            return fmt.Errorf("unknown %q, expected listeners or locations", parts[2])
+        }
+
+        switch {
+        case len(parts) == 4, len(parts) == 5 && parts[4] == "value":
+            return validate(v)
+        case len(parts) == 5 && parts[4] == ".nginx":
+            return validateDirectives(v)
+        }
+        return fmt.Errorf("unknown key, expected %s/<name>[/value|/.nginx]", parts[2])
+    case "upstreams":
+        if len(parts) != 4 || parts[1] == "" || parts[2] != "servers" || parts[3] == "" {
+            return fmt.Errorf("unknown key, expected upstreams/<name>/servers/<server>")
+        }
+        return validateFields(v, "url")
+    case "streams":
+        if len(parts) != 2 || parts[1] == "" {
+            return fmt.Errorf("unknown key, expected streams/<name>")
+        }
+        return validateStream(v)
+    }
+    return fmt.Errorf("unknown %q, expected settings, hosts, upstreams or streams", parts[0])
+}
+
+func validateListener(v string) error {
+    o, err := getFields(v, "protocol", "address")
+    if err != nil {
+        return err
+    }
+    if p := o["protocol"]; p != "http" && p != "https" {
+        return fmt.Errorf("invalid protocol %v, expected http or https", p)
+    }
+    return nil
+}
+
+func validateLocation(v string) error {
+    o, err := getFields(v, "path", "upstream")
+    if err != nil {
+        return err
+    }
+    if p, ok := o["path"].(string); !ok || !isLocationPath(p) {
+        return fmt.Errorf("invalid path %v, it must start with / or a location modifier (=, ~, ~* or ^~)", o["path"])
+    }
+    return nil
+}
+
+// isLocationPath reports whether p is a location argument, either a prefix
+// path or a path following one of the nginx location modifiers, which is a
+// regular expression for "~" and "~*".
+func isLocationPath(p string) bool {
+    parts := strings.SplitN(p, " ", 2)
+    if len(parts) == 1 {
+        return strings.HasPrefix(p, "/")
+    }
+
+    path := strings.TrimLeft(parts[1], " ")
+    switch parts[0] {
+    case "=", "^~":
+        return strings.HasPrefix(path, "/")
+    case "~", "~*":
+        return path != ""
+    }
+    return false
+}
+
+func validateStream(v string) error {
+    o, err := getFields(v, "protocol", "address", "upstream")
+    if err != nil {
+        return err
+    }
+    if p := o["protocol"]; p != "tcp" && p != "udp" {
+        return fmt.Errorf("invalid protocol %v, expected tcp or udp", p)
+    }
+    return nil
+}
+
+// validateDirectives checks that v is a map of directives to their values.
+func validateDirectives(v string) error {
+    var o map[string]interface{}
+    if err := json.Unmarshal([]byte(v), &o); err != nil {
+        return fmt.Errorf("invalid JSON object: %v", err)
+    }
+    for directive, value := range o {
+        switch value.(type) {
+        case string, float64:
+        default:
+            return fmt.Errorf("directive %s must be a string or a number", directive)
+        }
+    }
+    return nil
+}
+
+func validateObject(v string) error {
+    var o map[string]interface{}
+    if err := json.Unmarshal([]byte(v), &o); err != nil {
+        return fmt.Errorf("invalid JSON object: %v", err)
+    }
+    return nil
+}
+
+func validateFields(v string, fields ...string) error {
+    _, err := getFields(v, fields...)
+    return err
+}
+
+// getFields parses v as a JSON object which must have every given field set
+// to a non-empty string or a number.
+func getFields(v string, fields ...string) (map[string]interface{}, error) {
+    var o map[string]interface{}
+    if err := json.Unmarshal([]byte(v), &o); err != nil {
+        return nil, fmt.Errorf("invalid JSON object: %v", err)
+    }
+
+    for _, field := range fields {
+        switch f := o[field].(type) {
+        case string:
+            if f != "" {
+                continue
+            }
+        case float64:
+            continue
+        case nil:
+            return nil, fmt.Errorf("missing %q", field)
+        }
+        return nil, fmt.Errorf("%q must be a non-empty string or a number", field)
+    }
+    return o, nil
+}
@@ -0,0 +1,108 @@
+package core
+
+import (
+    "reflect"
+    "strings"
+    "testing"
+)
+
+func TestValidateKV(t *testing.T) {
+    tests := []struct {
+        key string
+        value string
+        valid bool
+    }{
+        // settings
+        {"/settings", `{"worker_processes":2}`, true},
+        {"/settings/.nginx", `{"client_max_body_size":"10m"}`, true},
+        {"/settings", `not json`, false},
+        {"/settings/other", `{}`, false},
+
+        // listeners
+        {"/hosts/a.com/listeners/http", `{"protocol":"http","address":80}`, true},
+        {"/hosts/a.com/listeners/https", `{"protocol":"https","address":"443","secret":"ns/tls"}`, true},
+        {"/hosts/a.com/listners/http", `{"protocol":"http","address":80}`, false},
+        {"/hosts/a.com/listeners/http", `{"address":80}`, false},
+        {"/hosts/a.com/listeners/http", `{"protocol":"ftp","address":80}`, false},
+        {"/hosts/a.com/listeners/http", `{"protocol":"","address":80}`, false},
+
+        // locations
+        {"/hosts/a.com/locations/root", `{"path":"/","upstream":"web"}`, true},
+        {"/hosts/a.com/locations/root", `{"path":"api","upstream":"web"}`, false},
+        {"/hosts/a.com/locations/api", `{"path":"~ ^/api","upstream":"web"}`, true},
+        {"/hosts/a.com/locations/png", `{"path":"~* \\.png$","upstream":"web"}`, true},
+        {"/hosts/a.com/locations/exact", `{"path":"= /exact","upstream":"web"}`, true},
+        {"/hosts/a.com/locations/static", `{"path":"^~ /static","upstream":"web"}`, true},
+        {"/hosts/a.com/locations/root", `{"path":"= exact","upstream":"web"}`, false},
+        {"/hosts/a.com/locations/root", `{"path":"~","upstream":"web"}`, false},
+        {"/hosts/a.com/locations/root", `{"path":"! /x","upstream":"web"}`, false},
+        {"/hosts/a.com/locations/root", `{"path":"/"}`, false},
+        {"/hosts//locations/root", `{"path":"/","upstream":"web"}`, false},
+        {"/hosts/a.com/locations", `{"path":"/","upstream":"web"}`, false},
+
+        // /value and /.nginx forms
+        {"/hosts/a.com/listeners/http/value", `{"protocol":"http","address":80}`, true},
+        {"/hosts/a.com/listeners/http/value", `{"protocol":"http"}`, false},
+        {"/hosts/a.com/locations/root/value", `{"path":"/","upstream":"web"}`, true},
+        {"/hosts/a.com/locations/root/.nginx", `{"proxy_read_timeout":"60s","proxy_buffers":8}`, true},
+        {"/hosts/a.com/locations/root/.nginx", `{"proxy_set_header":["Host","a.com"]}`, false},
+        {"/hosts/a.com/locations/root/.nginx", `not json`, false},
+        {"/hosts/a.com/locations/root/other", `{}`, false},
+
+        // upstreams
+        {"/upstreams/web/servers/web-0", `{"url":"10.0.0.1:80"}`, true},
+        {"/upstreams/web/servers/web-0", `{}`, false},
+        {"/upstreams/web/server/web-0", `{"url":"10.0.0.1:80"}`, false},
+
+        // streams
+        {"/streams/dns", `{"protocol":"udp","address":53,"upstream":"dns"}`, true},
+        {"/streams/db", `{"protocol":"tcp","address":"5432","upstream":"db"}`, true},
+        {"/streams/db", `{"protocol":"http","address":"5432","upstream":"db"}`, false},
+        {"/streams/db", `{"protocol":"tcp","address":"5432"}`, false},
+        {"/streams/db/other", `{"protocol":"tcp","address":"5432","upstream":"db"}`, false},
+
+        // unknown
+        {"/other", `{}`, false},
+    }
+
+    for _, test := range tests {
+        err := validateKV(test.key, test.value)
+        if test.valid && err != nil {
+            t.Errorf("%s %s: unexpected error: %v", test.key, test.value, err)
+        }
+        if !test.valid && err == nil {
+            t.Errorf("%s %s: expected an error", test.key, test.value)
+        }
+    }
+}
+
+func TestValidate(t *testing.T) {
+    kvs := map[string]string{
+        "/lb/settings": `{}`,
+        "/lb/hosts/a.com/listners/http": `{"protocol":"http","address":80}`,
+        "/lb/hosts/a.com/listeners/http": `{"address":80}`,
+        "/lb/hosts/a.com/locations/root": `{"path":"/","upstream":"web"}`,
+    }
+
+    if err := Validate(map[string]string{"/lb/settings": `{}`}, "/lb"); err != nil {
+        t.Fatalf("unexpected error: %v", err)
+    }
+
+    err := Validate(kvs, "/lb")
+    errs, ok := err.(ValidationErrors)
+    if !ok {
+        t.Fatalf("expected ValidationErrors, got %T: %v", err, err)
+    }
+
+    keys := make([]string, 0, len(errs))
+    for _, e := range errs {
+        keys = append(keys, strings.SplitN(e, ": ", 2)[0])
+    }
+    expected := []string{
+        "/lb/hosts/a.com/listeners/http",
+        "/lb/hosts/a.com/listners/http",
+    }
+    if !reflect.DeepEqual(keys, expected) {
+        t.Errorf("expected errors for %v, got %v", expected, errs)
+    }
+}
@@ -0,0 +1,72 @@
+package pkg
+
+import (
+    "time"
+)
+
+// debouncer coalesces bursts of triggers into a single firing. It fires once
+// no trigger was received for quietPeriod, but never later than maxDelay
+// after the first trigger of a burst. Consecutive firings are at least
+// minInterval apart, which takes precedence over maxDelay.
+//
+// It isn't safe for concurrent use, it's meant to be driven from a single
+// select loop.
+type debouncer struct {
+    quietPeriod time.Duration
+    maxDelay    time.Duration
+    minInterval time.Duration
+    // first trigger of the pending burst, zero if none
+    first time.Time
+    // last time it fired
+    last  time.Time
+    timer *time.Timer
+}
+
+func newDebouncer(quietPeriod, maxDelay, minInterval time.Duration) *debouncer {
+    return &debouncer{
+        quietPeriod: quietPeriod,
+        maxDelay: maxDelay,
+        minInterval: minInterval,
+    }
+}
+
+// Trigger (re)schedules the next firing.
+func (d *debouncer) Trigger() {
+    now := time.Now()
+    if d.first.IsZero() {
+        d.first = now
+    }
+
+    if d.timer != nil {
+        d.timer.Stop()
+    }
+    d.timer = time.NewTimer(d.deadline(now).Sub(now))
+}
+
+// deadline returns when to fire for a trigger received at now.
+func (d *debouncer) deadline(now time.Time) time.Time {
+    deadline := now.Add(d.quietPeriod)
+    if maxDeadline := d.first.Add(d.maxDelay); deadline.After(maxDeadline) {
+        deadline = maxDeadline
+    }
+    if minDeadline := d.last.Add(d.minInterval); deadline.Before(minDeadline) {
+        deadline = minDeadline
+    }
+    return deadline
+}
+
+// C returns the channel to wait on for the next firing, nil if nothing is
+// pending. Fired must be called after receiving from it.
+func (d *debouncer) C() <-chan time.Time {
+    if d.timer == nil {
+        return nil
+    }
+    return d.timer.C
+}
+
+// Fired acknowledges a firing.
+func (d *debouncer) Fired() {
+    d.first = time.Time{}
+    d.last = time.Now()
+    d.timer = nil
+}
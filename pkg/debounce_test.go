@@ -0,0 +1,39 @@
+package pkg
+
+import (
+    "testing"
+    "time"
+)
+
+func TestDebouncerDeadline(t *testing.T) {
+    start := time.Unix(1000, 0)
+
+    // quiet period 2s, max delay 10s and min interval 5s, the burst starts
+    // at 0s and last is when it fired before, if it did
+    tests := []struct {
+        name string
+        fired bool
+        last time.Duration
+        now time.Duration
+        expected time.Duration
+    }{
+        {"quiet period after the first trigger", false, 0, 0, 2 * time.Second},
+        {"quiet period restarts on every trigger", false, 0, 3 * time.Second, 5 * time.Second},
+        {"clamped to the max delay", false, 0, 9 * time.Second, 10 * time.Second},
+        {"max delay already passed", false, 0, 11 * time.Second, 10 * time.Second},
+        {"min interval long passed", true, -time.Minute, 0, 2 * time.Second},
+        {"delayed until the min interval", true, -time.Second, 0, 4 * time.Second},
+        {"min interval wins over the max delay", true, 8 * time.Second, 9 * time.Second, 13 * time.Second},
+    }
+
+    for _, test := range tests {
+        d := newDebouncer(2 * time.Second, 10 * time.Second, 5 * time.Second)
+        d.first = start
+        if test.fired {
+            d.last = start.Add(test.last)
+        }
+        if deadline := d.deadline(start.Add(test.now)); !deadline.Equal(start.Add(test.expected)) {
+            t.Errorf("%s: expected %v, got %v", test.name, test.expected, deadline.Sub(start))
+        }
+    }
+}
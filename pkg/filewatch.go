@@ -0,0 +1,36 @@
+package pkg
+
+import (
+    "bytes"
+    "io/ioutil"
+    "time"
+
+    log "github.com/glerchundi/logrus"
+)
+
+// watchFile polls path every interval and notifies changes through the given
+// channel, it never returns. Contents are compared instead of modification
+// times so that atomically swapped files, like mounted config map volumes,
+// are noticed too.
+func watchFile(path string, interval time.Duration, changes chan<- struct{}) {
+    last, err := ioutil.ReadFile(path)
+    if err != nil {
+        log.Warnf("unable to read %s: %v", path, err)
+    }
+
+    for range time.Tick(interval) {
+        data, err := ioutil.ReadFile(path)
+        if err != nil {
+            log.Warnf("unable to read %s: %v", path, err)
+            continue
+        }
+
+        if bytes.Equal(data, last) {
+            continue
+        }
+        last = data
+
+        log.Infof("%s changed", path)
+        changes <- struct{}{}
+    }
+}
@@ -0,0 +1,125 @@
+package pkg
+
+import (
+    "fmt"
+    "net/http"
+    "sort"
+    "sync"
+    "time"
+)
+
+// health keeps track of what's needed to tell whether the controller is
+// alive and ready to serve traffic.
+type health struct {
+    mutex *sync.RWMutex
+    // when the controller started
+    started time.Time
+    // resources whose initial list has been processed
+    listed map[string]bool
+    // whether a render was ever attempted and its last result
+    rendered bool
+    renderErr error
+}
+
+func newHealth() *health {
+    return &health{
+        mutex: &sync.RWMutex{},
+        started: time.Now(),
+        listed: make(map[string]bool),
+    }
+}
+
+func (h *health) setListed(resource string) {
+    h.mutex.Lock()
+    defer h.mutex.Unlock()
+
+    h.listed[resource] = true
+}
+
+func (h *health) setRendered(err error) {
+    h.mutex.Lock()
+    defer h.mutex.Unlock()
+
+    h.rendered = true
+    h.renderErr = err
+}
+
+func (h *health) isRendered() bool {
+    h.mutex.RLock()
+    defer h.mutex.RUnlock()
+
+    return h.rendered
+}
+
+// healthz reports the process as healthy as long as every informer keeps
+// both of its loops running and talked to the master recently, and nginx is
+// running if supervised. nginx is started by the first render, it isn't
+// expected to run before.
+func (k2n *KubeToNginx) healthz(w http.ResponseWriter, r *http.Request) {
+    var problems []string
+    if k2n.nginx != nil && k2n.health.isRendered() && !k2n.nginx.Running() {
+        problems = append(problems, "nginx is not running")
+    }
+    for _, resource := range k2n.getResources() {
+        i := k2n.informers[resource]
+        if !i.Alive() {
+            problems = append(problems, fmt.Sprintf("%s informer is not running", resource))
+            continue
+        }
+
+        lastContact := i.LastContact()
+        if lastContact.Before(k2n.health.started) {
+            lastContact = k2n.health.started
+        }
+        if time.Since(lastContact) > k2n.config.HealthzTimeout {
+            problems = append(problems, fmt.Sprintf("%s informer didn't reach the master since %v", resource, lastContact))
+        }
+    }
+    writeHealth(w, problems)
+}
+
+// readyz reports the controller as ready once the initial list of every
+// watched resource was processed and the last render, including the check
+// and reload commands, succeeded. Supervised nginx must be running too.
+func (k2n *KubeToNginx) readyz(w http.ResponseWriter, r *http.Request) {
+    var problems []string
+    if k2n.nginx != nil && !k2n.nginx.Running() {
+        problems = append(problems, "nginx is not running")
+    }
+
+    k2n.health.mutex.RLock()
+    for _, resource := range k2n.getResources() {
+        if !k2n.health.listed[resource] {
+            problems = append(problems, fmt.Sprintf("%s not listed yet", resource))
+        }
+    }
+    if !k2n.health.rendered {
+        problems = append(problems, "config not rendered yet")
+    } else if k2n.health.renderErr != nil {
+        problems = append(problems, fmt.Sprintf("last render failed: %v", k2n.health.renderErr))
+    }
+    k2n.health.mutex.RUnlock()
+
+    writeHealth(w, problems)
+}
+
+func (k2n *KubeToNginx) getResources() []string {
+    resources := make([]string, 0, len(k2n.informers))
+    for resource := range k2n.informers {
+        resources = append(resources, resource)
+    }
+    sort.Strings(resources)
+    return resources
+}
+
+func writeHealth(w http.ResponseWriter, problems []string) {
+    w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+    if len(problems) > 0 {
+        w.WriteHeader(http.StatusServiceUnavailable)
+        for _, problem := range problems {
+            fmt.Fprintln(w, problem)
+        }
+        return
+    }
+    fmt.Fprintln(w, "ok")
+}
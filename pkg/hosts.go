@@ -0,0 +1,155 @@
+package pkg
+
+import (
+    "fmt"
+    "regexp"
+    "sort"
+    "strings"
+
+    log "github.com/glerchundi/logrus"
+    kapi "github.com/glerchundi/kubelistener/pkg/client/api/v1"
+)
+
+var (
+    // DNS names, optionally starting with a wildcard label
+    hostnameRegexp = regexp.MustCompile(`^(\*\.)?[A-Za-z0-9]([A-Za-z0-9-]*[A-Za-z0-9])?(\.[A-Za-z0-9]([A-Za-z0-9-]*[A-Za-z0-9])?)*$`)
+    // URI paths, without characters meaningful to nginx (i.e. quotes, "#",
+    // "$" or ";")
+    pathRegexp = regexp.MustCompile(`^/[A-Za-z0-9_.,:/=+*@%~!&-]*$`)
+)
+
+// serviceHost is a virtual host defined by a service, serving it under a
+// single path.
+type serviceHost struct {
+    host string
+    path string
+    protocol string
+    secret string
+}
+
+// getServiceHost returns the virtual host defined by the annotations of the
+// service or, failing that, by the host pattern. Services without any, or
+// with invalid ones, define no host.
+func (k2n *KubeToNginx) getServiceHost(s kapi.Service) (*serviceHost, error) {
+    host, ok := k2n.getAnnotation(s.Annotations, hostnameAnnotation)
+    if !ok {
+        if k2n.config.ServiceHostPattern == "" {
+            return nil, nil
+        }
+        host = strings.NewReplacer(
+            "{service}", s.Name,
+            "{namespace}", s.Namespace,
+        ).Replace(k2n.config.ServiceHostPattern)
+    }
+    if !hostnameRegexp.MatchString(host) {
+        return nil, fmt.Errorf("invalid hostname %q", host)
+    }
+
+    sh := &serviceHost{host: host, path: "/", protocol: "http"}
+    if path, ok := k2n.getAnnotation(s.Annotations, pathAnnotation); ok {
+        if !pathRegexp.MatchString(path) {
+            return nil, fmt.Errorf("invalid path %q, it must start with / and be a plain URI path", path)
+        }
+        sh.path = path
+    }
+    if protocol, ok := k2n.getAnnotation(s.Annotations, protocolAnnotation); ok {
+        if protocol != "http" && protocol != "https" {
+            return nil, fmt.Errorf("invalid protocol %q, expected http or https", protocol)
+        }
+        sh.protocol = protocol
+    }
+    // as with ingresses, secrets must live in the namespace of the service
+    if secret, ok := k2n.getAnnotation(s.Annotations, secretAnnotation); ok && secret != "" {
+        if parts := strings.Split(secret, "/"); len(parts) == 2 && parts[0] == s.Namespace {
+            secret = parts[1]
+        }
+        if secret == "" || strings.Contains(secret, "/") {
+            return nil, fmt.Errorf("invalid secret %q, it must live in namespace %s", secret, s.Namespace)
+        }
+        sh.secret = getObjectKey(s.Namespace, secret)
+    }
+    return sh, nil
+}
+
+// getServiceHostsData translates the hosts defined by exposed services into
+// listeners and locations pointing to their default upstream. Explicit data,
+// from ingress resources and the ingresses data, wins: hosts defined there
+// are left alone. Services can't add paths to hosts defined by services of
+// other namespaces either.
+func (k2n *KubeToNginx) getServiceHostsData(explicit map[string]string) map[string]string {
+    kvs := make(map[string]string)
+
+    defined := getDefinedHosts(explicit)
+    paths := make(map[string]string)
+    namespaces := make(map[string]string)
+
+    keys := make([]string, 0, len(k2n.services))
+    for key := range k2n.services {
+        keys = append(keys, key)
+    }
+    sort.Strings(keys)
+
+    for _, key := range keys {
+        s := k2n.services[key]
+        if !k2n.isExposed(s) || len(s.Spec.Ports) == 0 {
+            continue
+        }
+
+        sh, err := k2n.getServiceHost(s)
+        if err != nil {
+            log.Warnf("service %s: %v, ignoring its host", key, err)
+            continue
+        }
+        if sh == nil {
+            continue
+        }
+
+        if defined[sh.host] {
+            log.Warnf("service %s: host %s is already defined by the ingresses data or ingress resources, ignoring it", key, sh.host)
+            continue
+        }
+        if namespace, ok := namespaces[sh.host]; ok && namespace != s.Namespace {
+            log.Warnf("service %s: host %s is already defined by services in namespace %s, ignoring it", key, sh.host, namespace)
+            continue
+        }
+        if owner, ok := paths[sh.host+sh.path]; ok {
+            log.Warnf("service %s: %s%s is already served by service %s, ignoring it", key, sh.host, sh.path, owner)
+            continue
+        }
+        paths[sh.host+sh.path] = key
+        namespaces[sh.host] = s.Namespace
+
+        listener := map[string]string{
+            "protocol": sh.protocol,
+            "address": "80",
+        }
+        if sh.protocol == "https" {
+            listener["address"] = "443"
+            if sh.secret != "" {
+                listener["secret"] = sh.secret
+            }
+        }
+        kvs[getListenerKey(sh.host, sh.protocol)] = toJson(listener)
+
+        owner := fmt.Sprintf("service %s", key)
+        setWithDirectives(kvs, getLocationKey(sh.host, fmt.Sprintf("service-%s-%s", s.Namespace, s.Name)), toJson(map[string]string{
+            "path": sh.path,
+            "upstream": getUpstreamName(k2n.getUpstreamNamespace(s.Namespace), s.Name, ""),
+        }), k2n.getDirectives(owner, s.Annotations, locationDirectivesAnnotation))
+    }
+
+    return kvs
+}
+
+// getDefinedHosts returns the hosts having any listener or location in the
+// given key/value pairs.
+func getDefinedHosts(kvs map[string]string) map[string]bool {
+    hosts := make(map[string]bool)
+    for k := range kvs {
+        parts := strings.Split(strings.TrimPrefix(k, "/lb/hosts/"), "/")
+        if strings.HasPrefix(k, "/lb/hosts/") && len(parts) >= 2 && parts[0] != "" {
+            hosts[parts[0]] = true
+        }
+    }
+    return hosts
+}
@@ -0,0 +1,205 @@
+package pkg
+
+import (
+    "encoding/json"
+    "fmt"
+    "sort"
+    "strings"
+
+    kclient "github.com/glerchundi/kube2nginx/pkg/client"
+    "github.com/glerchundi/kube2nginx/pkg/core"
+    log "github.com/glerchundi/logrus"
+    kruntime "github.com/glerchundi/kubelistener/pkg/client/runtime"
+)
+
+func init() {
+    // Ingresses live in the extensions group, let informers know about them.
+    kclient.RegisterResource(
+        "ingresses", "extensions/v1beta1",
+        func() kruntime.Object { return &core.Ingress{} },
+        func() kruntime.Object { return &core.IngressList{} },
+    )
+}
+
+func (k2n *KubeToNginx) addIngress(i core.Ingress) {
+    k2n.ingresses[getObjectKey(i.Namespace, i.Name)] = i
+}
+
+func (k2n *KubeToNginx) deleteIngress(i core.Ingress) {
+    key := getObjectKey(i.Namespace, i.Name)
+    _, ok := k2n.ingresses[key]
+    if ok {
+        delete(k2n.ingresses, key)
+    }
+}
+
+func (k2n *KubeToNginx) updateIngress(i core.Ingress) {
+    k2n.ingresses[getObjectKey(i.Namespace, i.Name)] = i
+}
+
+// getIngressesData translates the known ingress resources into the same
+// key/value layout used by the user-provided ingresses data. Ingresses are
+// walked in a stable order so that, whenever two of them claim the same host
+// and path, the result doesn't depend on the order events were received.
+//
+// Directives annotated on ingresses apply to their hosts and locations, the
+// ones annotated on services to the locations pointing to them. Ingress ones
+// win over service ones.
+//
+// The first namespace claiming a host owns it, paths and TLS for that host
+// from ingresses of other namespaces are ignored. Otherwise any tenant could
+// hijack the certificate of a host served by another one.
+//
+// Along with the data, it returns the keys of the ingresses which contributed
+// at least one location, the ones actually being served.
+func (k2n *KubeToNginx) getIngressesData() (map[string]string, map[string]bool) {
+    kvs := make(map[string]string)
+    served := make(map[string]bool)
+    listeners := make(map[string]string)
+    hostDirectives := make(map[string]map[string]string)
+    namespaces := make(map[string]string)
+
+    keys := make([]string, 0, len(k2n.ingresses))
+    for key := range k2n.ingresses {
+        keys = append(keys, key)
+    }
+    sort.Strings(keys)
+
+    paths := make(map[string]string)
+    for _, key := range keys {
+        i := k2n.ingresses[key]
+        if i.Spec.Backend != nil {
+            log.Warnf("ingress %s: default backends are not supported, ignoring it", key)
+        }
+
+        owner := fmt.Sprintf("ingress %s", key)
+        hostAnnotated := k2n.getDirectives(owner, i.Annotations, hostDirectivesAnnotation)
+        locationAnnotated := k2n.getDirectives(owner, i.Annotations, locationDirectivesAnnotation)
+
+        secrets := make(map[string]string)
+        for _, tls := range i.Spec.TLS {
+            if tls.SecretName == "" {
+                continue
+            }
+            for _, host := range tls.Hosts {
+                secrets[host] = getObjectKey(i.Namespace, tls.SecretName)
+            }
+        }
+
+        n := 0
+        for _, rule := range i.Spec.Rules {
+            if rule.Host == "" {
+                log.Warnf("ingress %s: rules without host are not supported, ignoring it", key)
+                continue
+            }
+
+            if rule.HTTP == nil {
+                continue
+            }
+
+            if !hostnameRegexp.MatchString(rule.Host) {
+                log.Warnf("ingress %s: invalid host %q, ignoring it", key, rule.Host)
+                continue
+            }
+
+            if namespace, ok := namespaces[rule.Host]; ok && namespace != i.Namespace {
+                log.Warnf("ingress %s: host %s is already defined by ingresses in namespace %s, ignoring it", key, rule.Host, namespace)
+                continue
+            }
+            namespaces[rule.Host] = i.Namespace
+
+            for _, p := range rule.HTTP.Paths {
+                path := p.Path
+                if path == "" {
+                    path = "/"
+                }
+                if !pathRegexp.MatchString(path) {
+                    log.Warnf("ingress %s: invalid path %q for host %s, ignoring it", key, path, rule.Host)
+                    continue
+                }
+
+                if owner, ok := paths[rule.Host+path]; ok {
+                    log.Warnf("ingress %s: %s%s is already served by ingress %s, ignoring it", key, rule.Host, path, owner)
+                    continue
+                }
+                paths[rule.Host+path] = key
+
+                listeners[getListenerKey(rule.Host, "http")] = toJson(map[string]string{
+                    "protocol": "http",
+                    "address": "80",
+                })
+                if secret, ok := secrets[rule.Host]; ok {
+                    https := toJson(map[string]string{
+                        "protocol": "https",
+                        "address": "443",
+                        "secret": secret,
+                    })
+                    if listener, ok := listeners[getListenerKey(rule.Host, "https")]; ok && listener != https {
+                        log.Warnf("ingress %s: host %s already has a certificate, ignoring secret %s", key, rule.Host, secret)
+                    } else {
+                        listeners[getListenerKey(rule.Host, "https")] = https
+                    }
+                }
+                if hostAnnotated != nil {
+                    hostDirectives[rule.Host] = mergeDirectives(owner, hostDirectives[rule.Host], hostAnnotated)
+                }
+
+                directives := make(map[string]string)
+                if svc, ok := k2n.services[getObjectKey(i.Namespace, p.Backend.ServiceName)]; ok {
+                    svcOwner := fmt.Sprintf("service %s/%s", svc.Namespace, svc.Name)
+                    for directive, value := range k2n.getDirectives(svcOwner, svc.Annotations, locationDirectivesAnnotation) {
+                        directives[directive] = value
+                    }
+                }
+                for directive, value := range locationAnnotated {
+                    directives[directive] = value
+                }
+                setWithDirectives(kvs, getLocationKey(rule.Host, fmt.Sprintf("%s-%s-%d", i.Namespace, i.Name, n)), toJson(map[string]string{
+                    "path": path,
+                    "upstream": getBackendUpstreamName(k2n.getUpstreamNamespace(i.Namespace), p.Backend),
+                }), directives)
+                served[key] = true
+                n++
+            }
+        }
+    }
+
+    for key, value := range listeners {
+        host := strings.Split(strings.TrimPrefix(key, "/lb/hosts/"), "/")[0]
+        setWithDirectives(kvs, key, value, hostDirectives[host])
+    }
+
+    return kvs, served
+}
+
+// getBackendUpstreamName returns the upstream serving the given backend, the
+// service port is either referenced by name or number. Backends always live
+// in the same namespace as the ingress.
+func getBackendUpstreamName(namespace string, b core.IngressBackend) string {
+    port := b.ServicePort.String()
+    if port == "0" {
+        port = ""
+    }
+    return getUpstreamName(namespace, b.ServiceName, port)
+}
+
+func getListenerKey(host, listener string) string {
+    return fmt.Sprintf("/lb/hosts/%s/listeners/%s", host, listener)
+}
+
+func getLocationKey(host, location string) string {
+    return fmt.Sprintf("/lb/hosts/%s/locations/%s", host, location)
+}
+
+func getObjectKey(namespace, name string) string {
+    return fmt.Sprintf("%s/%s", namespace, name)
+}
+
+func toJson(v interface{}) string {
+    data, err := json.Marshal(v)
+    if err != nil {
+        // only called with plain maps and structs, it can't happen
+        panic(err)
+    }
+    return string(data)
+}
@@ -3,25 +3,52 @@ package pkg
 import (
     "encoding/base64"
     "encoding/json"
+    "fmt"
     "io/ioutil"
     "os"
     "os/signal"
+    "strings"
     "syscall"
     "time"
 
+    "github.com/ghodss/yaml"
+    kclient "github.com/glerchundi/kube2nginx/pkg/client"
     "github.com/glerchundi/kube2nginx/pkg/core"
+    "github.com/glerchundi/kube2nginx/pkg/metrics"
     log "github.com/glerchundi/logrus"
-    kclient "github.com/glerchundi/kubelistener/pkg/client"
     kapi "github.com/glerchundi/kubelistener/pkg/client/api/v1"
-    "fmt"
 )
 
 type Config struct {
     KubeMasterURL string
+    KubeConfig string
     Namespace string
+    AllNamespaces bool
     Selector string
+    FieldSelector string
+    ExposeAnnotatedOnly bool
+    ServiceHostPattern string
     ResyncInterval time.Duration
+    Once bool
+    RenderQuietPeriod time.Duration
+    RenderMaxDelay time.Duration
+    ReloadMinInterval time.Duration
+    WatchIngresses bool
+    UseEndpoints bool
+    SyncCerts bool
+    CertsDir string
     IngressesData string
+    IngressesFile string
+    IngressesFilePollInterval time.Duration
+    IngressesConfigMap string
+    IngressesConfigMapKey string
+    ValidateIngressesData bool
+    AnnotationsPrefix string
+    AllowedDirectives []string
+    PublishStatus bool
+    PublishAddress string
+    ListenAddress string
+    HealthzTimeout time.Duration
     NginxSrc string
     NginxDest string
     NginxDestUid int
@@ -29,15 +56,54 @@ type Config struct {
     NginxDestMode string
     NginxCheckCmd string
     NginxReloadCmd string
+    NginxTemplates string
+    NginxSupervise bool
+    NginxBin string
+    NginxShutdownTimeout time.Duration
+    NginxDiffMaxSize int
+    NginxChangelog string
 }
 
 func NewConfig() *Config {
     return &Config{
         IngressesData: "",
+        IngressesFilePollInterval: 5 * time.Second,
+        IngressesConfigMap: "",
+        IngressesConfigMapKey: "ingresses.json",
+        ValidateIngressesData: true,
         KubeMasterURL: "",
+        KubeConfig: "",
         Namespace: "",
+        AllNamespaces: false,
         Selector: "",
+        FieldSelector: "",
+        ExposeAnnotatedOnly: false,
+        ServiceHostPattern: "",
         ResyncInterval: 1 * time.Minute,
+        Once: false,
+        RenderQuietPeriod: 1 * time.Second,
+        RenderMaxDelay: 10 * time.Second,
+        ReloadMinInterval: 5 * time.Second,
+        AnnotationsPrefix: "kube2nginx",
+        AllowedDirectives: []string{
+            "client_max_body_size",
+            "proxy_buffering",
+            "proxy_buffer_size",
+            "proxy_buffers",
+            "proxy_connect_timeout",
+            "proxy_read_timeout",
+            "proxy_send_timeout",
+            "proxy_http_version",
+            "keepalive_timeout",
+        },
+        PublishStatus: false,
+        PublishAddress: "",
+        ListenAddress: ":9090",
+        HealthzTimeout: 2 * time.Minute,
+        WatchIngresses: false,
+        UseEndpoints: false,
+        SyncCerts: false,
+        CertsDir: "/etc/nginx/certs",
         NginxSrc: "",
         NginxDest: "/etc/nginx/nginx.conf",
         NginxDestUid: os.Getuid(),
@@ -45,6 +111,12 @@ func NewConfig() *Config {
         NginxDestMode: "0644",
         NginxCheckCmd: "/usr/sbin/nginx -t -c {{.}}",
         NginxReloadCmd: "/usr/sbin/nginx -s reload",
+        NginxTemplates: "",
+        NginxSupervise: false,
+        NginxBin: "/usr/sbin/nginx",
+        NginxShutdownTimeout: 30 * time.Second,
+        NginxDiffMaxSize: 16 * 1024,
+        NginxChangelog: "",
     }
 }
 
@@ -53,110 +125,260 @@ type KubeToNginx struct {
     config *Config
     // instantiated template
     tmpl *core.Template
+    // additional template resources
+    templates []core.ResourceConfig
     // parsed ingresses data
     ingressesData map[string]string
-    // runtime upstreams data
-    upstreamsData map[string]string
+    // ingresses data from the config map, nil if not available
+    configMapData map[string]string
+    // runtime service resources
+    services map[string]kapi.Service
+    // last problem reported for each service, if any
+    serviceProblems map[string]string
+    // runtime endpoints resources
+    endpoints map[string]kapi.Endpoints
+    // runtime ingress resources
+    ingresses map[string]core.Ingress
+    // runtime secret resources
+    secrets map[string]kapi.Secret
+    // kubernetes client
+    client *kclient.Client
+    // informers by resource and namespace
+    informers map[string]*kclient.Informer
+    // liveness and readiness status
+    health *health
+    // nginx child process, if supervised
+    nginx *supervisor
+    // address published into ingresses status
+    address kapi.LoadBalancerIngress
 }
 
 func NewKubeToNginx(config *Config) *KubeToNginx {
     return &KubeToNginx{
         config: config,
         tmpl: nil,
+        templates: nil,
         ingressesData: make(map[string]string),
-        upstreamsData: make(map[string]string),
+        configMapData: nil,
+        services: make(map[string]kapi.Service),
+        serviceProblems: make(map[string]string),
+        endpoints: make(map[string]kapi.Endpoints),
+        ingresses: make(map[string]core.Ingress),
+        secrets: make(map[string]kapi.Secret),
+        client: nil,
+        informers: make(map[string]*kclient.Informer),
+        health: newHealth(),
+        nginx: nil,
     }
 }
 
 func (k2n *KubeToNginx) Run() {
-    if k2n.config.IngressesData == "" {
+    if k2n.config.IngressesData == "" && k2n.config.IngressesFile == "" && k2n.config.IngressesConfigMap == "" && !k2n.config.WatchIngresses {
         log.Fatal("no ingresses, no way")
     }
 
-    id, err := base64.StdEncoding.DecodeString(k2n.config.IngressesData)
-    if err != nil {
-        log.Fatal(err)
+    if k2n.config.PublishStatus && !k2n.config.WatchIngresses {
+        log.Fatal("ingresses status can only be published if they are watched")
     }
 
-    k2n.ingressesData = make(map[string]string)
-    if err := json.Unmarshal(id, &k2n.ingressesData); err != nil {
+    if err := k2n.loadIngressesData(); err != nil {
         log.Fatal(err)
     }
 
-    // Get service account token
-    serviceAccountToken, err := ioutil.ReadFile("/var/run/secrets/kubernetes.io/serviceaccount/token")
-    if err != nil {
+    if err := k2n.loadTemplates(); err != nil {
         log.Fatal(err)
     }
 
-    // Get CA certificate data
-    caCertificate, err := ioutil.ReadFile("/var/run/secrets/kubernetes.io/serviceaccount/ca.crt")
+    // Create new k8s client
+    kubeConfig, err := k2n.newClientConfig()
     if err != nil {
         log.Fatal(err)
     }
-
-    // Create new k8s client
-    kubeConfig := &kclient.ClientConfig{
-        MasterURL: k2n.config.KubeMasterURL,
-        Auth: &kclient.TokenAuth{ string(serviceAccountToken) },
-        CaCertificate: caCertificate,
-    }
     kubeClient, err := kclient.NewClient(kubeConfig)
     if err != nil {
         log.Fatal(err)
     }
+    k2n.client = kubeClient
+
+    if k2n.config.PublishStatus {
+        k2n.address, err = k2n.getPublishAddress()
+        if err != nil {
+            log.Fatal(err)
+        }
+    }
 
     // Flow control channels
-    recvChan := make(chan interface{}, 100)
+    recvChan := make(chan *item)
     stopChan := make(<-chan struct{})
     doneChan := make(chan bool)
     errChan := make(chan error, 10)
 
-    // Create informer from client
-    informerConfig := &kclient.InformerConfig{
-        Namespace: k2n.config.Namespace,
-        Resource: "services",
-        Selector: k2n.config.Selector,
-        ResyncInterval: k2n.config.ResyncInterval,
+    // Create informers from client
+    resources := []string{"services"}
+    if k2n.config.UseEndpoints {
+        resources = append(resources, "endpoints")
     }
-    i, err := kubeClient.NewInformer(
-        informerConfig, recvChan,
-        stopChan, doneChan, errChan,
-    )
-    if err != nil {
-        log.Fatal(err)
+    if k2n.config.WatchIngresses {
+        resources = append(resources, "ingresses")
+    }
+    if k2n.config.SyncCerts {
+        resources = append(resources, "secrets")
     }
 
-    tmplCfg := &core.TemplateConfig{
-        SrcData:   core.NginxConf,
-        Dest:      k2n.config.NginxDest,
-        Uid:       k2n.config.NginxDestUid,
-        Gid:       k2n.config.NginxDestGid,
-        Mode:      k2n.config.NginxDestMode,
-        Prefix:    "/lb",
-        CheckCmd:  k2n.config.NginxCheckCmd,
-        ReloadCmd: k2n.config.NginxReloadCmd,
+    // Every namespace gets its own informers, lists must be told apart so
+    // that they only replace the resources of the namespace they come from.
+    newInformer := func(namespace, resource, selector, fieldSelector string) {
+        informerConfig := &kclient.InformerConfig{
+            Namespace: namespace,
+            AllNamespaces: namespace == "",
+            Resource: resource,
+            Selector: selector,
+            FieldSelector: fieldSelector,
+            ResyncInterval: k2n.config.ResyncInterval,
+        }
+        informerChan := make(chan interface{}, 100)
+        i, err := kubeClient.NewInformer(
+            informerConfig, informerChan,
+            stopChan, make(chan bool), errChan,
+        )
+        if err != nil {
+            log.Fatal(err)
+        }
+        k2n.informers[getInformerKey(namespace, resource)] = i
+
+        if k2n.config.Once {
+            v, err := i.List()
+            if err != nil {
+                log.Fatal(err)
+            }
+            k2n.process(namespace, v)
+            return
+        }
+
+        go func() {
+            for v := range informerChan {
+                recvChan <- &item{namespace, v}
+            }
+        }()
     }
 
-    if k2n.config.NginxSrc != "" {
-        tmplCfg.Src = k2n.config.NginxSrc
+    for _, namespace := range k2n.getNamespaces() {
+        for _, resource := range resources {
+            // Selectors narrow down the exported services, endpoints of the
+            // left out ones are simply never used. Only TLS secrets are
+            // needed, others (i.e. service account tokens) aren't cached.
+            switch resource {
+            case "services":
+                newInformer(namespace, resource, k2n.config.Selector, k2n.config.FieldSelector)
+            case "secrets":
+                newInformer(namespace, resource, "", "type="+string(core.SecretTypeTLS))
+            default:
+                newInformer(namespace, resource, "", "")
+            }
+        }
+    }
+
+    // The ingresses data config map lives in a single namespace, whatever
+    // the watched ones are, and it's the only one worth listing.
+    if k2n.config.IngressesConfigMap != "" {
+        namespace, name := k2n.getIngressesConfigMap()
+        newInformer(namespace, "configmaps", "", "metadata.name="+name)
+    }
+
+    if k2n.config.Once {
+        k2n.renderOnce()
+        return
+    }
+
+    if k2n.config.NginxSupervise {
+        k2n.nginx = newSupervisor(
+            k2n.config.NginxBin,
+            k2n.config.NginxDest,
+            k2n.config.NginxShutdownTimeout,
+        )
     }
 
-    k2n.tmpl = core.NewTemplate(tmplCfg, false, false, false)
+    k2n.tmpl = core.NewTemplate(k2n.newTemplateConfig(), false, false, false)
 
-    go i.Run()
+    // nginx is started by the first reload, which must happen even if the
+    // config on disk is already up to date (i.e. kept in a volume)
+    if k2n.nginx != nil {
+        k2n.tmpl.ForceSync()
+    }
+
+    metrics.NewCounterFunc(
+        "kube2nginx_events_coalesced_total",
+        "Number of events delivered by informers as part of a snapshot because they couldn't be delivered on their own.",
+        func() float64 {
+            coalesced := uint64(0)
+            for _, i := range k2n.informers {
+                coalesced += i.Coalesced()
+            }
+            return float64(coalesced)
+        },
+    )
+    metrics.NewCounterFunc(
+        "kube2nginx_informer_relists_total",
+        "Number of relists forced by informers because changes might have been missed.",
+        func() float64 {
+            relists := uint64(0)
+            for _, i := range k2n.informers {
+                relists += i.Relists()
+            }
+            return float64(relists)
+        },
+    )
+
+    if k2n.config.ListenAddress != "" {
+        go k2n.serve()
+    }
+
+    for _, i := range k2n.informers {
+        go i.Run()
+    }
+
+    // The ingresses file is reloaded whenever it changes
+    fileChan := make(chan struct{})
+    if k2n.config.IngressesFile != "" && k2n.config.IngressesFilePollInterval > 0 {
+        go watchFile(k2n.config.IngressesFile, k2n.config.IngressesFilePollInterval, fileChan)
+    }
+
+    // Bursts of events are rendered at once
+    d := newDebouncer(
+        k2n.config.RenderQuietPeriod,
+        k2n.config.RenderMaxDelay,
+        k2n.config.ReloadMinInterval,
+    )
 
     // Wait for signal
     signalChan := make(chan os.Signal, 1)
     signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM)
     for {
         select {
-        case v := <-recvChan:
-            k2n.process(v)
+        case i := <-recvChan:
+            if k2n.process(i.namespace, i.v) {
+                d.Trigger()
+            }
+        case <-fileChan:
+            if err := k2n.loadIngressesData(); err != nil {
+                log.Errorf("keeping the last valid ingresses data: %v", err)
+                continue
+            }
+            d.Trigger()
+        case <-d.C():
+            d.Fired()
+            k2n.render()
         case err := <-errChan:
+            informerErrorsTotal.Inc()
             log.Error(err)
         case s := <-signalChan:
             log.Infof("Captured %v. Exiting...", s)
+            if k2n.config.PublishStatus {
+                k2n.clearStatus()
+            }
+            if k2n.nginx != nil {
+                k2n.nginx.Stop()
+            }
             close(doneChan)
         case <-doneChan:
             os.Exit(0)
@@ -164,68 +386,378 @@ func (k2n *KubeToNginx) Run() {
     }
 }
 
-func (k2n *KubeToNginx) process(v interface{}) {
+// item is a list or watch event received by the informers of a namespace,
+// empty if they watch all of them.
+type item struct {
+    namespace string
+    v interface{}
+}
+
+// process updates the runtime state with a list or watch event, it returns
+// false if nothing was done with it. Lists only replace the resources of the
+// namespace they were received from.
+func (k2n *KubeToNginx) process(namespace string, v interface{}) bool {
     switch vv := v.(type) {
     case *kapi.ServiceList:
-        k2n.upstreamsData = make(map[string]string)
+        for key := range k2n.services {
+            if inNamespace(key, namespace) {
+                delete(k2n.services, key)
+            }
+        }
         for _, s := range vv.Items {
             k2n.addService(s)
         }
+        for key := range k2n.serviceProblems {
+            if _, ok := k2n.services[key]; !ok && inNamespace(key, namespace) {
+                delete(k2n.serviceProblems, key)
+            }
+        }
+        k2n.health.setListed(getInformerKey(namespace, "services"))
+    case *kapi.EndpointsList:
+        for key := range k2n.endpoints {
+            if inNamespace(key, namespace) {
+                delete(k2n.endpoints, key)
+            }
+        }
+        for _, e := range vv.Items {
+            k2n.addEndpoints(e)
+        }
+        k2n.health.setListed(getInformerKey(namespace, "endpoints"))
+    case *kapi.SecretList:
+        for key := range k2n.secrets {
+            if inNamespace(key, namespace) {
+                delete(k2n.secrets, key)
+            }
+        }
+        for _, s := range vv.Items {
+            k2n.addSecret(s)
+        }
+        k2n.health.setListed(getInformerKey(namespace, "secrets"))
+    case *core.ConfigMapList:
+        k2n.health.setListed(getInformerKey(namespace, "configmaps"))
+        for _, c := range vv.Items {
+            if k2n.isIngressesConfigMap(c) {
+                return k2n.setConfigMap(c)
+            }
+        }
+        namespace, name := k2n.getIngressesConfigMap()
+        return k2n.deleteConfigMap(core.ConfigMap{ObjectMeta: kapi.ObjectMeta{Namespace: namespace, Name: name}})
+    case *core.IngressList:
+        for key := range k2n.ingresses {
+            if inNamespace(key, namespace) {
+                delete(k2n.ingresses, key)
+            }
+        }
+        for _, i := range vv.Items {
+            k2n.addIngress(i)
+        }
+        k2n.health.setListed(getInformerKey(namespace, "ingresses"))
     case *kapi.WatchEvent:
-        s, ok := vv.Object.(*kapi.Service)
-        if !ok {
+        switch o := vv.Object.(type) {
+        case *kapi.Service:
+            switch vv.Type {
+            case kapi.Added:
+                k2n.addService(*o)
+            case kapi.Deleted:
+                k2n.deleteService(*o)
+            case kapi.Modified:
+                k2n.updateService(*o)
+            }
+        case *kapi.Endpoints:
+            switch vv.Type {
+            case kapi.Added:
+                k2n.addEndpoints(*o)
+            case kapi.Deleted:
+                k2n.deleteEndpoints(*o)
+            case kapi.Modified:
+                k2n.updateEndpoints(*o)
+            }
+        case *kapi.Secret:
+            switch vv.Type {
+            case kapi.Added:
+                k2n.addSecret(*o)
+            case kapi.Deleted:
+                k2n.deleteSecret(*o)
+            case kapi.Modified:
+                k2n.updateSecret(*o)
+            }
+        case *core.ConfigMap:
+            if !k2n.isIngressesConfigMap(*o) {
+                return false
+            }
+            switch vv.Type {
+            case kapi.Added, kapi.Modified:
+                return k2n.setConfigMap(*o)
+            case kapi.Deleted:
+                return k2n.deleteConfigMap(*o)
+            }
+        case *core.Ingress:
+            switch vv.Type {
+            case kapi.Added:
+                k2n.addIngress(*o)
+            case kapi.Deleted:
+                k2n.deleteIngress(*o)
+            case kapi.Modified:
+                k2n.updateIngress(*o)
+            }
+        default:
             log.Warnf("unknown k8s api object in a watch event was received: %v", vv.Object)
-            return
-        }
-
-        switch vv.Type {
-        case kapi.Added:
-            k2n.addService(*s)
-        case kapi.Deleted:
-            k2n.deleteService(*s)
-        case kapi.Modified:
-            k2n.updateService(*s)
+            return false
         }
     default:
         log.Warnf("unknown k8s api object was received: %v", v)
-        return
+        return false
     }
 
-    // mixup everything
-    kvs := make(map[string]string)
-    for k, v := range k2n.ingressesData {
-        kvs[k] = v
-    }
-    for k, v := range k2n.upstreamsData {
-        kvs[k] = v
+    return true
+}
+
+// render merges all the known data and renders it into nginx.conf.
+func (k2n *KubeToNginx) render() {
+    kvs, served := k2n.getKVs()
+    updateStoreMetrics(kvs)
+
+    // certificates must be in place before nginx checks the config, if any
+    // of them changed nginx needs to be reloaded to pick it up
+    if k2n.config.SyncCerts {
+        changed, err := k2n.syncCerts(kvs)
+        if err != nil {
+            log.Errorf("unable to sync certificates: %v", err)
+        }
+        if changed {
+            k2n.tmpl.ForceSync()
+        }
     }
 
     // render template
     err := k2n.tmpl.Render(kvs); if err != nil {
         log.Error(err)
     }
+    k2n.health.setRendered(err)
+
+    if err == nil && k2n.config.PublishStatus {
+        k2n.publishStatus(served)
+    }
 }
 
-func (k2n *KubeToNginx) addService(s kapi.Service) {
-    k2n.upstreamsData[getUpstreamKey(s)] = getUpstreamValue(s)
+// renderOnce renders the config out of the resources listed once, the check
+// command is run, even if the config didn't change, but nginx isn't
+// reloaded. Any failure is fatal.
+func (k2n *KubeToNginx) renderOnce() {
+    kvs, _ := k2n.getKVs()
+    updateStoreMetrics(kvs)
+
+    if k2n.config.SyncCerts {
+        if _, err := k2n.syncCerts(kvs); err != nil {
+            log.Fatalf("unable to sync certificates: %v", err)
+        }
+    }
+
+    tmplCfg := k2n.newTemplateConfig()
+    tmplCfg.ReloadCmd = ""
+    tmplCfg.Reload = nil
+    tmpl := core.NewTemplate(tmplCfg, false, false, false)
+    tmpl.ForceSync()
+    if err := tmpl.Render(kvs); err != nil {
+        log.Fatal(err)
+    }
+
+    log.Infof("%s rendered, exiting", k2n.config.NginxDest)
+}
+
+// getNamespaces returns the namespaces being watched, an empty one stands for
+// all of them.
+func (k2n *KubeToNginx) getNamespaces() []string {
+    if k2n.config.AllNamespaces {
+        return []string{""}
+    }
+
+    var namespaces []string
+    for _, namespace := range strings.Split(k2n.config.Namespace, ",") {
+        namespace = strings.TrimSpace(namespace)
+        if namespace != "" {
+            namespaces = append(namespaces, namespace)
+        }
+    }
+    if len(namespaces) == 0 {
+        namespaces = []string{k2n.getNamespace()}
+    }
+    return namespaces
 }
 
-func (k2n *KubeToNginx) deleteService(s kapi.Service) {
-    key := getUpstreamKey(s)
-    _, ok := k2n.upstreamsData[key]
-    if ok {
-        delete(k2n.upstreamsData, key)
+// getNamespace returns the main namespace, the first one being watched or the
+// pod's own one otherwise. It follows the same rules as informers do and is
+// used to resolve references lacking a namespace.
+func (k2n *KubeToNginx) getNamespace() string {
+    if !k2n.config.AllNamespaces {
+        for _, namespace := range strings.Split(k2n.config.Namespace, ",") {
+            namespace = strings.TrimSpace(namespace)
+            if namespace != "" {
+                return namespace
+            }
+        }
+    }
+
+    namespace := os.Getenv("POD_NAMESPACE")
+    if namespace == "" {
+        namespace = "default"
     }
+    return namespace
 }
 
-func (k2n *KubeToNginx) updateService(s kapi.Service) {
-    k2n.upstreamsData[getUpstreamKey(s)] = getUpstreamValue(s)
+// isMultiNamespace reports whether resources from several namespaces can be
+// received, upstream names of services outside the main namespace are
+// qualified with their namespace in that case.
+func (k2n *KubeToNginx) isMultiNamespace() bool {
+    return len(k2n.getNamespaces()) > 1 || k2n.config.AllNamespaces
 }
 
-func getUpstreamKey(s kapi.Service) string {
-    return fmt.Sprintf("/lb/upstreams/%s/servers/%s", s.Name, s.UID)
+func getInformerKey(namespace, resource string) string {
+    if namespace == "" {
+        namespace = "*"
+    }
+    return getObjectKey(namespace, resource)
 }
 
-func getUpstreamValue(s kapi.Service) string {
-    return fmt.Sprintf(`{"url": "%s:%s"}`, s.Spec.ClusterIP, s.Spec.Ports[0].TargetPort.String())
+// inNamespace reports whether the object key belongs to the namespace, all
+// of them do to the empty one.
+func inNamespace(key, namespace string) bool {
+    return namespace == "" || strings.HasPrefix(key, namespace+"/")
+}
+
+// newClientConfig returns the configuration to reach kubernetes master, from
+// the kubeconfig file if provided or from the service account otherwise.
+func (k2n *KubeToNginx) newClientConfig() (*kclient.ClientConfig, error) {
+    if k2n.config.KubeConfig != "" {
+        kubeConfig, namespace, err := loadKubeConfig(k2n.config.KubeConfig)
+        if err != nil {
+            return nil, err
+        }
+
+        if k2n.config.KubeMasterURL != "" {
+            kubeConfig.MasterURL = k2n.config.KubeMasterURL
+        }
+
+        if k2n.config.Namespace == "" {
+            k2n.config.Namespace = namespace
+        }
+
+        return kubeConfig, nil
+    }
+
+    // Get service account token
+    serviceAccountToken, err := ioutil.ReadFile("/var/run/secrets/kubernetes.io/serviceaccount/token")
+    if err != nil {
+        return nil, err
+    }
+
+    // Get CA certificate data
+    caCertificate, err := ioutil.ReadFile("/var/run/secrets/kubernetes.io/serviceaccount/ca.crt")
+    if err != nil {
+        return nil, err
+    }
+
+    return &kclient.ClientConfig{
+        MasterURL: k2n.config.KubeMasterURL,
+        Auth: &kclient.TokenAuth{Token: string(serviceAccountToken)},
+        CaCertificate: caCertificate,
+    }, nil
+}
+
+// loadIngressesData parses the user-provided ingresses data, both base64
+// encoded and from a JSON or YAML file. The file takes precedence on
+// conflicting keys. The current data is only replaced if everything was
+// parsed, and validated if configured to do so, successfully.
+func (k2n *KubeToNginx) loadIngressesData() error {
+    ingressesData := make(map[string]string)
+
+    if k2n.config.IngressesData != "" {
+        id, err := base64.StdEncoding.DecodeString(k2n.config.IngressesData)
+        if err != nil {
+            return err
+        }
+
+        if err := json.Unmarshal(id, &ingressesData); err != nil {
+            return err
+        }
+    }
+
+    if k2n.config.IngressesFile != "" {
+        id, err := ioutil.ReadFile(k2n.config.IngressesFile)
+        if err != nil {
+            return err
+        }
+
+        if err := yaml.Unmarshal(id, &ingressesData); err != nil {
+            return fmt.Errorf("unable to parse %s: %v", k2n.config.IngressesFile, err)
+        }
+    }
+
+    if err := k2n.validateIngressesData(ingressesData); err != nil {
+        return fmt.Errorf("invalid ingresses data: %v", err)
+    }
+
+    k2n.ingressesData = ingressesData
+    return nil
+}
+
+// validateIngressesData checks the user-provided ingresses data against the
+// layout understood by the default template. Custom templates may use their
+// own keys, validation can be disabled for them.
+func (k2n *KubeToNginx) validateIngressesData(ingressesData map[string]string) error {
+    if !k2n.config.ValidateIngressesData {
+        return nil
+    }
+    return core.Validate(ingressesData, "/lb")
+}
+
+// getKVs mixes up everything, user-provided ingresses data wins over the one
+// derived from ingress resources. The config map, if available, replaces the
+// ingresses data given through flags. Hosts defined by services only fill
+// in what neither of them serves. The ingresses being served are returned
+// too.
+func (k2n *KubeToNginx) getKVs() (map[string]string, map[string]bool) {
+    kvs, served := k2n.getIngressesData()
+    ingressesData := k2n.ingressesData
+    if k2n.configMapData != nil {
+        ingressesData = k2n.configMapData
+    }
+    for k, v := range ingressesData {
+        kvs[k] = v
+    }
+    for k, v := range k2n.getServiceHostsData(kvs) {
+        kvs[k] = v
+    }
+    for k, v := range k2n.getUpstreamsData() {
+        kvs[k] = v
+    }
+    return kvs, served
+}
+
+func (k2n *KubeToNginx) newTemplateConfig() *core.TemplateConfig {
+    tmplCfg := &core.TemplateConfig{
+        SrcData:       core.NginxConf,
+        Dest:          k2n.config.NginxDest,
+        Uid:           k2n.config.NginxDestUid,
+        Gid:           k2n.config.NginxDestGid,
+        Mode:          k2n.config.NginxDestMode,
+        Prefix:        "/lb",
+        CheckCmd:      k2n.config.NginxCheckCmd,
+        ReloadCmd:     k2n.config.NginxReloadCmd,
+        DiffMaxSize:   k2n.config.NginxDiffMaxSize,
+        ChangelogFile: k2n.config.NginxChangelog,
+        CertsDir:      k2n.config.CertsDir,
+        Resources:     k2n.templates,
+    }
+
+    // nginx started by ourselves is reloaded through signals
+    if k2n.nginx != nil {
+        tmplCfg.Reload = k2n.nginx.Reload
+    }
+
+    if k2n.config.NginxSrc != "" {
+        tmplCfg.Src = k2n.config.NginxSrc
+    }
+
+    return tmplCfg
 }
\ No newline at end of file
@@ -0,0 +1,150 @@
+package pkg
+
+import (
+    "fmt"
+    "io/ioutil"
+    "path/filepath"
+
+    "github.com/ghodss/yaml"
+    kclient "github.com/glerchundi/kube2nginx/pkg/client"
+)
+
+// kubeConfig is the subset of the kubeconfig file format understood by
+// kube2nginx.
+type kubeConfig struct {
+    CurrentContext string `json:"current-context"`
+    Clusters []struct {
+        Name string `json:"name"`
+        Cluster struct {
+            Server string `json:"server"`
+            CertificateAuthority string `json:"certificate-authority"`
+            CertificateAuthorityData []byte `json:"certificate-authority-data"`
+            InsecureSkipTLSVerify bool `json:"insecure-skip-tls-verify"`
+        } `json:"cluster"`
+    } `json:"clusters"`
+    Contexts []struct {
+        Name string `json:"name"`
+        Context struct {
+            Cluster string `json:"cluster"`
+            User string `json:"user"`
+            Namespace string `json:"namespace"`
+        } `json:"context"`
+    } `json:"contexts"`
+    Users []struct {
+        Name string `json:"name"`
+        User struct {
+            ClientCertificate string `json:"client-certificate"`
+            ClientCertificateData []byte `json:"client-certificate-data"`
+            ClientKey string `json:"client-key"`
+            ClientKeyData []byte `json:"client-key-data"`
+            Token string `json:"token"`
+            Username string `json:"username"`
+            Password string `json:"password"`
+        } `json:"user"`
+    } `json:"users"`
+}
+
+// loadKubeConfig builds a client configuration out of the current context of
+// a kubeconfig file, it also returns the namespace set in it, if any.
+func loadKubeConfig(path string) (*kclient.ClientConfig, string, error) {
+    data, err := ioutil.ReadFile(path)
+    if err != nil {
+        return nil, "", err
+    }
+
+    kc := &kubeConfig{}
+    if err := yaml.Unmarshal(data, kc); err != nil {
+        return nil, "", fmt.Errorf("unable to parse %s: %v", path, err)
+    }
+
+    if kc.CurrentContext == "" {
+        return nil, "", fmt.Errorf("%s: no current context", path)
+    }
+
+    var clusterName, userName, namespace string
+    found := false
+    for _, c := range kc.Contexts {
+        if c.Name == kc.CurrentContext {
+            clusterName, userName, namespace = c.Context.Cluster, c.Context.User, c.Context.Namespace
+            found = true
+            break
+        }
+    }
+    if !found {
+        return nil, "", fmt.Errorf("%s: context %q not found", path, kc.CurrentContext)
+    }
+
+    // relative paths are relative to the kubeconfig file itself
+    dir := filepath.Dir(path)
+    readFile := func(data []byte, file string) ([]byte, error) {
+        if len(data) > 0 || file == "" {
+            return data, nil
+        }
+        if !filepath.IsAbs(file) {
+            file = filepath.Join(dir, file)
+        }
+        return ioutil.ReadFile(file)
+    }
+
+    config := &kclient.ClientConfig{}
+
+    found = false
+    for _, c := range kc.Clusters {
+        if c.Name == clusterName {
+            config.MasterURL = c.Cluster.Server
+            config.CaCertificate, err = readFile(c.Cluster.CertificateAuthorityData, c.Cluster.CertificateAuthority)
+            if err != nil {
+                return nil, "", err
+            }
+            if len(config.CaCertificate) == 0 {
+                config.CaCertificate = nil
+            }
+            config.InsecureSkipVerify = c.Cluster.InsecureSkipTLSVerify
+            found = true
+            break
+        }
+    }
+    if !found {
+        return nil, "", fmt.Errorf("%s: cluster %q not found", path, clusterName)
+    }
+
+    found = false
+    for _, u := range kc.Users {
+        if u.Name != userName {
+            continue
+        }
+
+        clientCertificate, err := readFile(u.User.ClientCertificateData, u.User.ClientCertificate)
+        if err != nil {
+            return nil, "", err
+        }
+        clientKey, err := readFile(u.User.ClientKeyData, u.User.ClientKey)
+        if err != nil {
+            return nil, "", err
+        }
+
+        switch {
+        case len(clientCertificate) > 0 && len(clientKey) > 0:
+            config.Auth = &kclient.ClientCertificateAuth{
+                ClientCertificate: clientCertificate,
+                ClientKey: clientKey,
+            }
+        case u.User.Token != "":
+            config.Auth = &kclient.TokenAuth{Token: u.User.Token}
+        case u.User.Username != "":
+            config.Auth = &kclient.UsernameAndPasswordAuth{
+                Username: u.User.Username,
+                Password: u.User.Password,
+            }
+        default:
+            return nil, "", fmt.Errorf("%s: user %q has no supported credentials", path, userName)
+        }
+        found = true
+        break
+    }
+    if !found {
+        return nil, "", fmt.Errorf("%s: user %q not found", path, userName)
+    }
+
+    return config, namespace, nil
+}
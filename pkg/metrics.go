@@ -0,0 +1,68 @@
+package pkg
+
+import (
+    "strings"
+
+    "github.com/glerchundi/kube2nginx/pkg/metrics"
+)
+
+var (
+    informerErrorsTotal = metrics.NewCounter(
+        "kube2nginx_informer_errors_total",
+        "Number of errors reported by informers.",
+    )
+    upstreamsGauge = metrics.NewGauge(
+        "kube2nginx_upstreams",
+        "Number of upstreams currently in the store.",
+    )
+    serversGauge = metrics.NewGauge(
+        "kube2nginx_servers",
+        "Number of upstream servers currently in the store.",
+    )
+    hostsGauge = metrics.NewGauge(
+        "kube2nginx_hosts",
+        "Number of hosts currently in the store.",
+    )
+    streamsGauge = metrics.NewGauge(
+        "kube2nginx_streams",
+        "Number of tcp and udp streams currently in the store.",
+    )
+    nginxRestartsTotal = metrics.NewCounter(
+        "kube2nginx_nginx_restarts_total",
+        "Number of times the supervised nginx died unexpectedly and was restarted.",
+    )
+)
+
+// updateStoreMetrics counts the upstreams, servers, hosts and streams present
+// in the key/value store about to be rendered.
+func updateStoreMetrics(kvs map[string]string) {
+    upstreams := make(map[string]bool)
+    hosts := make(map[string]bool)
+    servers := 0
+    streams := 0
+    for k := range kvs {
+        parts := strings.Split(k, "/")
+        // "", "lb", "upstreams|hosts|streams", <name>, ...
+        if len(parts) < 4 || parts[1] != "lb" {
+            continue
+        }
+        switch parts[2] {
+        case "upstreams":
+            if len(parts) == 6 && parts[4] == "servers" {
+                upstreams[parts[3]] = true
+                servers++
+            }
+        case "hosts":
+            hosts[parts[3]] = true
+        case "streams":
+            if len(parts) == 4 {
+                streams++
+            }
+        }
+    }
+
+    upstreamsGauge.Set(float64(len(upstreams)))
+    serversGauge.Set(float64(servers))
+    hostsGauge.Set(float64(len(hosts)))
+    streamsGauge.Set(float64(streams))
+}
@@ -0,0 +1,198 @@
+// Package metrics implements the bare minimum needed to expose counters,
+// gauges and summaries in the Prometheus text exposition format.
+package metrics
+
+import (
+    "bytes"
+    "fmt"
+    "math"
+    "net/http"
+    "sort"
+    "strconv"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+type metric interface {
+    name() string
+    write(w *bytes.Buffer)
+}
+
+var (
+    registryMutex = &sync.Mutex{}
+    registry      = make(map[string]metric)
+)
+
+func register(m metric) {
+    registryMutex.Lock()
+    defer registryMutex.Unlock()
+
+    if _, ok := registry[m.name()]; ok {
+        panic(fmt.Sprintf("metric %s already registered", m.name()))
+    }
+    registry[m.name()] = m
+}
+
+// Handler returns an http handler which writes all registered metrics.
+func Handler() http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        registryMutex.Lock()
+        names := make([]string, 0, len(registry))
+        for name := range registry {
+            names = append(names, name)
+        }
+        sort.Strings(names)
+
+        var buf bytes.Buffer
+        for _, name := range names {
+            registry[name].write(&buf)
+        }
+        registryMutex.Unlock()
+
+        w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+        w.Write(buf.Bytes())
+    })
+}
+
+// value is a float64 which can be safely modified from several goroutines.
+type value struct {
+    bits uint64
+}
+
+func (v *value) add(delta float64) {
+    for {
+        old := atomic.LoadUint64(&v.bits)
+        new := math.Float64bits(math.Float64frombits(old) + delta)
+        if atomic.CompareAndSwapUint64(&v.bits, old, new) {
+            return
+        }
+    }
+}
+
+func (v *value) set(f float64) {
+    atomic.StoreUint64(&v.bits, math.Float64bits(f))
+}
+
+func (v *value) get() float64 {
+    return math.Float64frombits(atomic.LoadUint64(&v.bits))
+}
+
+// Counter is a cumulative metric which only goes up.
+type Counter struct {
+    n, help string
+    v       value
+}
+
+func NewCounter(name, help string) *Counter {
+    c := &Counter{n: name, help: help}
+    register(c)
+    return c
+}
+
+func (c *Counter) Inc() {
+    c.v.add(1)
+}
+
+func (c *Counter) Add(delta float64) {
+    if delta < 0 {
+        panic("counter cannot decrease in value")
+    }
+    c.v.add(delta)
+}
+
+func (c *Counter) name() string { return c.n }
+
+func (c *Counter) write(w *bytes.Buffer) {
+    writeHeader(w, c.n, c.help, "counter")
+    writeSample(w, c.n, c.v.get())
+}
+
+// Gauge is a metric which can arbitrarily go up and down.
+type Gauge struct {
+    n, help string
+    v       value
+}
+
+func NewGauge(name, help string) *Gauge {
+    g := &Gauge{n: name, help: help}
+    register(g)
+    return g
+}
+
+func (g *Gauge) Set(f float64) {
+    g.v.set(f)
+}
+
+// SetToCurrentTime sets the gauge to the current unix time in seconds.
+func (g *Gauge) SetToCurrentTime() {
+    g.v.set(float64(time.Now().UnixNano()) / 1e9)
+}
+
+func (g *Gauge) name() string { return g.n }
+
+func (g *Gauge) write(w *bytes.Buffer) {
+    writeHeader(w, g.n, g.help, "gauge")
+    writeSample(w, g.n, g.v.get())
+}
+
+// CounterFunc is a counter whose value is computed when collected, useful to
+// expose counts already kept elsewhere.
+type CounterFunc struct {
+    n, help string
+    fn      func() float64
+}
+
+func NewCounterFunc(name, help string, fn func() float64) *CounterFunc {
+    c := &CounterFunc{n: name, help: help, fn: fn}
+    register(c)
+    return c
+}
+
+func (c *CounterFunc) name() string { return c.n }
+
+func (c *CounterFunc) write(w *bytes.Buffer) {
+    writeHeader(w, c.n, c.help, "counter")
+    writeSample(w, c.n, c.fn())
+}
+
+// Summary tracks the count and sum of observations, quantiles aren't
+// computed.
+type Summary struct {
+    n, help string
+    count   value
+    sum     value
+}
+
+func NewSummary(name, help string) *Summary {
+    s := &Summary{n: name, help: help}
+    register(s)
+    return s
+}
+
+func (s *Summary) Observe(f float64) {
+    s.count.add(1)
+    s.sum.add(f)
+}
+
+// ObserveSince observes the seconds elapsed since t.
+func (s *Summary) ObserveSince(t time.Time) {
+    s.Observe(time.Since(t).Seconds())
+}
+
+func (s *Summary) name() string { return s.n }
+
+func (s *Summary) write(w *bytes.Buffer) {
+    writeHeader(w, s.n, s.help, "summary")
+    writeSample(w, s.n+"_sum", s.sum.get())
+    writeSample(w, s.n+"_count", s.count.get())
+}
+
+func writeHeader(w *bytes.Buffer, name, help, typ string) {
+    fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+    fmt.Fprintf(w, "# TYPE %s %s\n", name, typ)
+}
+
+func writeSample(w *bytes.Buffer, name string, f float64) {
+    fmt.Fprintf(w, "%s %s\n", name, strconv.FormatFloat(f, 'g', -1, 64))
+}
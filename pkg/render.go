@@ -0,0 +1,82 @@
+package pkg
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "os"
+
+    "github.com/glerchundi/kube2nginx/pkg/core"
+    kapi "github.com/glerchundi/kubelistener/pkg/client/api/v1"
+)
+
+type RenderConfig struct {
+    *Config
+    ServicesFile string
+    EndpointsFile string
+    Output string
+}
+
+func NewRenderConfig() *RenderConfig {
+    return &RenderConfig{
+        Config: NewConfig(),
+        ServicesFile: "",
+        EndpointsFile: "",
+        Output: "-",
+    }
+}
+
+// Render generates nginx.conf offline, resources are read from files instead
+// of being listed from kubernetes and neither the check nor the reload
+// commands are run. The result is written to Output, "-" means stdout.
+func Render(config *RenderConfig) error {
+    k2n := NewKubeToNginx(config.Config)
+    if err := k2n.loadIngressesData(); err != nil {
+        return err
+    }
+
+    if config.ServicesFile != "" {
+        services := &kapi.ServiceList{}
+        if err := readJsonFile(config.ServicesFile, services); err != nil {
+            return err
+        }
+        k2n.process("", services)
+    }
+
+    if config.EndpointsFile != "" {
+        endpoints := &kapi.EndpointsList{}
+        if err := readJsonFile(config.EndpointsFile, endpoints); err != nil {
+            return err
+        }
+        k2n.process("", endpoints)
+    }
+
+    tmpl := core.NewTemplate(k2n.newTemplateConfig(), true, false, false)
+
+    kvs, _ := k2n.getKVs()
+    var buf bytes.Buffer
+    if err := tmpl.Execute(kvs, &buf); err != nil {
+        return err
+    }
+
+    if config.Output == "-" {
+        _, err := os.Stdout.Write(buf.Bytes())
+        return err
+    }
+
+    return ioutil.WriteFile(config.Output, buf.Bytes(), 0644)
+}
+
+func readJsonFile(path string, v interface{}) error {
+    data, err := ioutil.ReadFile(path)
+    if err != nil {
+        return err
+    }
+
+    if err := json.Unmarshal(data, v); err != nil {
+        return fmt.Errorf("unable to parse %s: %v", path, err)
+    }
+
+    return nil
+}
@@ -0,0 +1,20 @@
+package pkg
+
+import (
+    "net/http"
+
+    "github.com/glerchundi/kube2nginx/pkg/metrics"
+    log "github.com/glerchundi/logrus"
+)
+
+// serve exposes the controller metrics and health through http, it never
+// returns.
+func (k2n *KubeToNginx) serve() {
+    mux := http.NewServeMux()
+    mux.Handle("/metrics", metrics.Handler())
+    mux.HandleFunc("/healthz", k2n.healthz)
+    mux.HandleFunc("/readyz", k2n.readyz)
+
+    log.Infof("Listening on %s", k2n.config.ListenAddress)
+    log.Fatal(http.ListenAndServe(k2n.config.ListenAddress, mux))
+}
@@ -0,0 +1,114 @@
+package pkg
+
+import (
+    "fmt"
+    "net"
+    "os"
+
+    "github.com/glerchundi/kube2nginx/pkg/core"
+    log "github.com/glerchundi/logrus"
+    kapi "github.com/glerchundi/kubelistener/pkg/client/api/v1"
+)
+
+// getPublishAddress returns the address published into ingresses status,
+// the configured one or the address of the node we're running on, found
+// through NODE_NAME. External addresses are preferred over internal ones.
+func (k2n *KubeToNginx) getPublishAddress() (kapi.LoadBalancerIngress, error) {
+    address := k2n.config.PublishAddress
+    if address == "" {
+        nodeName := os.Getenv("NODE_NAME")
+        if nodeName == "" {
+            return kapi.LoadBalancerIngress{}, fmt.Errorf("neither a publish address nor the NODE_NAME environment variable were provided")
+        }
+
+        node := &kapi.Node{}
+        if err := k2n.client.Get("nodes", "", nodeName, "", node); err != nil {
+            return kapi.LoadBalancerIngress{}, err
+        }
+
+        for _, addressType := range []kapi.NodeAddressType{kapi.NodeExternalIP, kapi.NodeInternalIP} {
+            for _, a := range node.Status.Addresses {
+                if a.Type == addressType && address == "" {
+                    address = a.Address
+                }
+            }
+        }
+        if address == "" {
+            return kapi.LoadBalancerIngress{}, fmt.Errorf("node %s has no addresses", nodeName)
+        }
+    }
+
+    if net.ParseIP(address) != nil {
+        return kapi.LoadBalancerIngress{IP: address}, nil
+    }
+    return kapi.LoadBalancerIngress{Hostname: address}, nil
+}
+
+// publishStatus adds our address to the status of the served ingresses,
+// those which contributed at least one location, and removes it from the
+// rest. Addresses published by others, like other replicas, are kept.
+func (k2n *KubeToNginx) publishStatus(served map[string]bool) {
+    for key, i := range k2n.ingresses {
+        if served[key] {
+            k2n.addAddress(i)
+        } else {
+            k2n.removeAddress(i)
+        }
+    }
+}
+
+// clearStatus removes our address from the status of every known ingress.
+func (k2n *KubeToNginx) clearStatus() {
+    for _, i := range k2n.ingresses {
+        k2n.removeAddress(i)
+    }
+}
+
+func (k2n *KubeToNginx) addAddress(i core.Ingress) {
+    if hasAddress(i, k2n.address) {
+        return
+    }
+
+    i.Status.LoadBalancer.Ingress = append(
+        append([]kapi.LoadBalancerIngress{}, i.Status.LoadBalancer.Ingress...),
+        k2n.address,
+    )
+    k2n.updateIngressStatus(i)
+}
+
+func (k2n *KubeToNginx) removeAddress(i core.Ingress) {
+    if !hasAddress(i, k2n.address) {
+        return
+    }
+
+    var lbis []kapi.LoadBalancerIngress
+    for _, lbi := range i.Status.LoadBalancer.Ingress {
+        if lbi != k2n.address {
+            lbis = append(lbis, lbi)
+        }
+    }
+    i.Status.LoadBalancer.Ingress = lbis
+    k2n.updateIngressStatus(i)
+}
+
+// updateIngressStatus writes the status of the ingress, on conflict it's
+// retried after the newer version is received through the watch.
+func (k2n *KubeToNginx) updateIngressStatus(i core.Ingress) {
+    key := getObjectKey(i.Namespace, i.Name)
+    if err := k2n.client.Update("ingresses", i.Namespace, i.Name, "status", &i); err != nil {
+        log.Errorf("unable to update ingress %s status: %v", key, err)
+        return
+    }
+
+    log.Infof("ingress %s status updated to %v", key, i.Status.LoadBalancer.Ingress)
+    k2n.ingresses[key] = i
+}
+
+func hasAddress(i core.Ingress, address kapi.LoadBalancerIngress) bool {
+    for _, lbi := range i.Status.LoadBalancer.Ingress {
+        if lbi == address {
+            return true
+        }
+    }
+    return false
+}
@@ -0,0 +1,172 @@
+package pkg
+
+import (
+    "bufio"
+    "errors"
+    "io"
+    "os/exec"
+    "sync"
+    "syscall"
+    "time"
+
+    log "github.com/glerchundi/logrus"
+)
+
+// supervisor runs nginx in the foreground as a child process, reloads and
+// shutdowns are requested through signals and it's restarted whenever it
+// dies unexpectedly. Its output and exit status end up in our logs.
+type supervisor struct {
+    bin string
+    conf string
+    shutdownTimeout time.Duration
+    mutex *sync.Mutex
+    // running process, nil if none
+    cmd *exec.Cmd
+    // closed once the running process exits
+    exited chan struct{}
+    // whether it's being shut down
+    stopping bool
+}
+
+func newSupervisor(bin, conf string, shutdownTimeout time.Duration) *supervisor {
+    return &supervisor{
+        bin: bin,
+        conf: conf,
+        shutdownTimeout: shutdownTimeout,
+        mutex: &sync.Mutex{},
+    }
+}
+
+// Reload starts nginx if it isn't running yet, it's told to reload its
+// configuration otherwise.
+func (s *supervisor) Reload() error {
+    s.mutex.Lock()
+    defer s.mutex.Unlock()
+
+    if s.stopping {
+        return errors.New("nginx is shutting down")
+    }
+
+    if s.cmd == nil {
+        return s.start()
+    }
+
+    log.Debugf("Sending SIGHUP to nginx (pid %d)", s.cmd.Process.Pid)
+    return s.cmd.Process.Signal(syscall.SIGHUP)
+}
+
+// Stop gracefully shuts nginx down, it's killed if it doesn't exit within
+// the shutdown timeout. It won't be restarted anymore.
+func (s *supervisor) Stop() {
+    s.mutex.Lock()
+    s.stopping = true
+    cmd, exited := s.cmd, s.exited
+    s.mutex.Unlock()
+
+    if cmd == nil {
+        return
+    }
+
+    log.Infof("Sending SIGQUIT to nginx (pid %d)", cmd.Process.Pid)
+    if err := cmd.Process.Signal(syscall.SIGQUIT); err != nil {
+        log.Errorf("unable to signal nginx: %v", err)
+    }
+
+    select {
+    case <-exited:
+    case <-time.After(s.shutdownTimeout):
+        log.Warnf("nginx didn't exit within %v, killing it", s.shutdownTimeout)
+        cmd.Process.Kill()
+        <-exited
+    }
+}
+
+// Running reports whether nginx is running.
+func (s *supervisor) Running() bool {
+    s.mutex.Lock()
+    defer s.mutex.Unlock()
+
+    return s.cmd != nil
+}
+
+// start runs a new nginx process, the mutex must be held.
+func (s *supervisor) start() error {
+    cmd := exec.Command(s.bin, "-c", s.conf, "-g", "daemon off;")
+    stdout, err := cmd.StdoutPipe()
+    if err != nil {
+        return err
+    }
+    stderr, err := cmd.StderrPipe()
+    if err != nil {
+        return err
+    }
+
+    if err := cmd.Start(); err != nil {
+        return err
+    }
+    log.Infof("nginx started (pid %d)", cmd.Process.Pid)
+
+    s.cmd = cmd
+    s.exited = make(chan struct{})
+
+    go s.wait(cmd, s.exited, stdout, stderr)
+    return nil
+}
+
+// wait forwards the output of the process until it exits, then it's
+// restarted unless it was asked to stop.
+func (s *supervisor) wait(cmd *exec.Cmd, exited chan struct{}, stdout, stderr io.Reader) {
+    // pipes must be drained before waiting for the process
+    wg := &sync.WaitGroup{}
+    wg.Add(2)
+    go logLines(wg, stdout)
+    go logLines(wg, stderr)
+    wg.Wait()
+
+    err := cmd.Wait()
+    status := "exit status 0"
+    if err != nil {
+        status = err.Error()
+    }
+
+    s.mutex.Lock()
+    s.cmd = nil
+    close(exited)
+    stopping := s.stopping
+    s.mutex.Unlock()
+
+    if stopping {
+        log.Infof("nginx (pid %d) exited: %s", cmd.Process.Pid, status)
+        return
+    }
+
+    log.Errorf("nginx (pid %d) exited unexpectedly: %s", cmd.Process.Pid, status)
+    nginxRestartsTotal.Inc()
+
+    for {
+        // don't spin if it keeps dying right away
+        time.Sleep(1 * time.Second)
+
+        s.mutex.Lock()
+        if s.stopping || s.cmd != nil {
+            s.mutex.Unlock()
+            return
+        }
+        err := s.start()
+        s.mutex.Unlock()
+
+        if err == nil {
+            return
+        }
+        log.Errorf("unable to restart nginx: %v", err)
+    }
+}
+
+func logLines(wg *sync.WaitGroup, r io.Reader) {
+    defer wg.Done()
+
+    scanner := bufio.NewScanner(r)
+    for scanner.Scan() {
+        log.Infof("nginx: %s", scanner.Text())
+    }
+}
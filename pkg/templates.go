@@ -0,0 +1,67 @@
+package pkg
+
+import (
+    "fmt"
+    "io/ioutil"
+    "strings"
+
+    "github.com/ghodss/yaml"
+    "github.com/glerchundi/kube2nginx/pkg/core"
+)
+
+// templateResource describes, in the templates file, an additional template
+// rendered along with nginx.conf.
+type templateResource struct {
+    Src string `json:"src"`
+    Dest string `json:"dest"`
+    Uid *int `json:"uid"`
+    Gid *int `json:"gid"`
+    Mode string `json:"mode"`
+    Each string `json:"each"`
+}
+
+// loadTemplates parses the templates file, a YAML or JSON list of template
+// resources. Owner and group default to the nginx.conf ones.
+func (k2n *KubeToNginx) loadTemplates() error {
+    k2n.templates = nil
+    if k2n.config.NginxTemplates == "" {
+        return nil
+    }
+
+    data, err := ioutil.ReadFile(k2n.config.NginxTemplates)
+    if err != nil {
+        return err
+    }
+
+    var trs []templateResource
+    if err := yaml.Unmarshal(data, &trs); err != nil {
+        return fmt.Errorf("unable to parse %s: %v", k2n.config.NginxTemplates, err)
+    }
+
+    for n, tr := range trs {
+        if tr.Src == "" || tr.Dest == "" {
+            return fmt.Errorf("%s: template %d: both src and dest are required", k2n.config.NginxTemplates, n)
+        }
+        if tr.Each != "" && strings.Count(tr.Dest, "*") != 1 {
+            return fmt.Errorf("%s: template %d: dest must contain exactly one '*' when each is set", k2n.config.NginxTemplates, n)
+        }
+
+        r := core.ResourceConfig{
+            Src: tr.Src,
+            Dest: tr.Dest,
+            Uid: k2n.config.NginxDestUid,
+            Gid: k2n.config.NginxDestGid,
+            Mode: tr.Mode,
+            Each: tr.Each,
+        }
+        if tr.Uid != nil {
+            r.Uid = *tr.Uid
+        }
+        if tr.Gid != nil {
+            r.Gid = *tr.Gid
+        }
+        k2n.templates = append(k2n.templates, r)
+    }
+
+    return nil
+}
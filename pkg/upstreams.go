@@ -0,0 +1,189 @@
+package pkg
+
+import (
+    "fmt"
+    "strconv"
+    "strings"
+
+    log "github.com/glerchundi/logrus"
+    kapi "github.com/glerchundi/kubelistener/pkg/client/api/v1"
+)
+
+func (k2n *KubeToNginx) addService(s kapi.Service) {
+    k2n.checkService(s)
+    k2n.services[getObjectKey(s.Namespace, s.Name)] = s
+}
+
+func (k2n *KubeToNginx) deleteService(s kapi.Service) {
+    key := getObjectKey(s.Namespace, s.Name)
+    _, ok := k2n.services[key]
+    if ok {
+        delete(k2n.services, key)
+    }
+    delete(k2n.serviceProblems, key)
+}
+
+func (k2n *KubeToNginx) updateService(s kapi.Service) {
+    k2n.checkService(s)
+    k2n.services[getObjectKey(s.Namespace, s.Name)] = s
+}
+
+// checkService warns about services which won't produce any upstream, they
+// are kept anyway so that a later update can fix them. Services are checked
+// again on every resync, so problems are only reported when they change.
+func (k2n *KubeToNginx) checkService(s kapi.Service) {
+    key := getObjectKey(s.Namespace, s.Name)
+
+    var problems []string
+    if v, ok := k2n.getAnnotation(s.Annotations, exposeAnnotation); ok && k2n.config.ExposeAnnotatedOnly {
+        if _, err := strconv.ParseBool(v); err != nil {
+            problems = append(problems, fmt.Sprintf("has an invalid %s annotation %q", exposeAnnotation, v))
+        }
+    }
+    if len(s.Spec.Ports) == 0 {
+        problems = append(problems, "has no ports")
+    } else if !k2n.config.UseEndpoints && !hasClusterIP(s) {
+        problems = append(problems, "has no cluster ip")
+    }
+
+    problem := strings.Join(problems, " and ")
+    if problem == k2n.serviceProblems[key] {
+        return
+    }
+    if problem == "" {
+        delete(k2n.serviceProblems, key)
+        return
+    }
+    k2n.serviceProblems[key] = problem
+    log.Warnf("service %s %s, skipping it", key, problem)
+}
+
+func (k2n *KubeToNginx) addEndpoints(e kapi.Endpoints) {
+    k2n.endpoints[getObjectKey(e.Namespace, e.Name)] = e
+}
+
+func (k2n *KubeToNginx) deleteEndpoints(e kapi.Endpoints) {
+    key := getObjectKey(e.Namespace, e.Name)
+    _, ok := k2n.endpoints[key]
+    if ok {
+        delete(k2n.endpoints, key)
+    }
+}
+
+func (k2n *KubeToNginx) updateEndpoints(e kapi.Endpoints) {
+    k2n.endpoints[getObjectKey(e.Namespace, e.Name)] = e
+}
+
+// getUpstreamsData translates the known services, or their endpoints if
+// configured to do so, into upstream servers. Pointing nginx to the pods
+// directly lets it balance the load, keep connections alive and failover by
+// itself instead of relying on kube-proxy.
+//
+// Every service port produces an upstream named after its number and, if
+// any, its name, like "<service>_<port>". The first port is also reachable
+// through the bare service name for backwards compatibility. When several
+// namespaces are watched names of services outside the main namespace are
+// qualified with their namespace, like "<service>.<namespace>_<port>", so
+// that equally named services don't collide. Services not exposed are left
+// out.
+func (k2n *KubeToNginx) getUpstreamsData() map[string]string {
+    kvs := make(map[string]string)
+
+    for key, s := range k2n.services {
+        if !k2n.isExposed(s) {
+            continue
+        }
+
+        for n, port := range s.Spec.Ports {
+            var urls []string
+            if k2n.config.UseEndpoints {
+                e, ok := k2n.endpoints[key]
+                if !ok {
+                    continue
+                }
+                urls = getEndpointsURLs(e, port)
+            } else {
+                if !hasClusterIP(s) {
+                    continue
+                }
+                urls = []string{fmt.Sprintf("%s:%d", s.Spec.ClusterIP, port.Port)}
+            }
+
+            namespace := k2n.getUpstreamNamespace(s.Namespace)
+            upstreams := []string{getUpstreamName(namespace, s.Name, strconv.Itoa(port.Port))}
+            if port.Name != "" {
+                upstreams = append(upstreams, getUpstreamName(namespace, s.Name, port.Name))
+            }
+            if n == 0 {
+                upstreams = append(upstreams, getUpstreamName(namespace, s.Name, ""))
+            }
+
+            for _, upstream := range upstreams {
+                for _, url := range urls {
+                    kvs[getServerKey(upstream, url)] = getServerValue(url)
+                }
+            }
+        }
+    }
+
+    return kvs
+}
+
+// getEndpointsURLs returns the ready addresses backing the given service
+// port, endpoint ports are matched against it by name.
+func getEndpointsURLs(e kapi.Endpoints, port kapi.ServicePort) []string {
+    var urls []string
+    for _, subset := range e.Subsets {
+        for _, p := range subset.Ports {
+            if p.Name != port.Name {
+                continue
+            }
+            // only ready addresses are eligible, not ready ones are left out
+            // until they pass their readiness checks
+            for _, address := range subset.Addresses {
+                urls = append(urls, fmt.Sprintf("%s:%d", address.IP, p.Port))
+            }
+        }
+    }
+    return urls
+}
+
+func hasClusterIP(s kapi.Service) bool {
+    return s.Spec.ClusterIP != "" && s.Spec.ClusterIP != kapi.ClusterIPNone
+}
+
+// getUpstreamNamespace returns the namespace upstream names of services
+// living in the given one must be qualified with, if any. Services of the
+// main namespace keep unqualified names, so that watching more namespaces
+// doesn't break the ingresses data referencing them.
+func (k2n *KubeToNginx) getUpstreamNamespace(namespace string) string {
+    if !k2n.isMultiNamespace() || namespace == k2n.getNamespace() {
+        return ""
+    }
+    return namespace
+}
+
+// getUpstreamName returns the upstream name for a service port, referenced
+// by name or number. An empty port refers to the default (first) one and an
+// empty namespace leaves the name unqualified. Neither "." nor "_" are valid
+// in service, namespace and port names, so names of different services or
+// ports never collide (i.e. service "web" port "http" and service
+// "web-http").
+func getUpstreamName(namespace, service, port string) string {
+    name := service
+    if namespace != "" {
+        name = fmt.Sprintf("%s.%s", service, namespace)
+    }
+    if port == "" {
+        return name
+    }
+    return fmt.Sprintf("%s_%s", name, port)
+}
+
+func getServerKey(upstream, server string) string {
+    return fmt.Sprintf("/lb/upstreams/%s/servers/%s", upstream, server)
+}
+
+func getServerValue(url string) string {
+    return toJson(map[string]string{"url": url})
+}